@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// hasBearerToken reports whether r carries "Authorization: Bearer <token>"
+func hasBearerToken(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && secureCompare(got, token)
+}
+
+// secureCompare compares secrets in constant time
+func secureCompare(got, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// requireAdmin only lets requests carrying the admin bearer token through to next
+func requireAdmin(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasBearerToken(r, token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// registerAdminRoutes mounts the /admin/* endpoints behind the admin token
+func registerAdminRoutes(rt *router, cfg Config) {
+	token := cfg.AdminToken
+	rt.HandleFunc("GET /admin/config", "Effective config, secrets redacted", requireAdmin(token, debugConfigHandler(cfg)))
+	rt.HandleFunc("GET /admin/schema", "Migration version and users table columns", requireAdmin(token, adminSchemaHandler))
+	rt.HandleFunc("POST /admin/migrate", "Apply pending migrations", requireAdmin(token, migrateHandler))
+	rt.HandleFunc("GET /admin/maintenance", "Whether maintenance mode is on", requireAdmin(token, maintenanceHandler))
+	rt.HandleFunc("PUT /admin/maintenance", "Toggle maintenance mode", requireAdmin(token, setMaintenanceHandler))
+	rt.HandleFunc("GET /admin/flags", "Effective feature flags", requireAdmin(token, flagsHandler))
+	rt.HandleFunc("PUT /admin/flags", "Override feature flags", requireAdmin(token, setFlagsHandler))
+}
+
+// adminSchemaHandler reports the applied migration version and the users columns
+func adminSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	version, err := schemaVersion(r.Context())
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	columns, err := usersColumns(r.Context())
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"migration_version": version,
+		"table":             "users",
+		"columns":           columns,
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdminRoutesNeedToken(t *testing.T) {
+	h := newTestHandler(testConfig(t, "ADMIN_TOKEN", "s3cret"))
+	for _, route := range [][2]string{
+		{"GET", "/admin/schema"},
+		{"GET", "/admin/config"},
+		{"POST", "/admin/migrate"},
+		{"GET", "/admin/flags"},
+		{"PUT", "/admin/maintenance"},
+	} {
+		for _, auth := range []string{"", "Bearer wrong", "s3cret", "Basic czNjcmV0"} {
+			rec := serve(h, route[0], route[1], "", "Authorization", auth)
+			if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("%s %s with %q: status = %d, want 401 with a challenge", route[0], route[1], auth, rec.Code)
+			}
+		}
+	}
+}
+
+func TestAdminRoutesOffWithoutToken(t *testing.T) {
+	h := newTestHandler(testConfig(t))
+	if rec := serve(h, "GET", "/admin/schema", "", "Authorization", "Bearer "); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 when ADMIN_TOKEN is unset", rec.Code)
+	}
+}
+
+func TestAdminConfigRedactsSecrets(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "metrics-password")
+	if err := os.WriteFile(secretFile, []byte("from-a-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig(t,
+		"DATABASE_URL", "postgres://app:hunter2@db:5432/users",
+		"DATABASE_READ_URL", "postgres://app@replica:5432/users?sslmode=verify-full&password=hunter3&sslpassword=k3ypass",
+		"ADMIN_TOKEN", "s3cret",
+		"METRICS_TOKEN", "m3trics",
+		"METRICS_PASSWORD_FILE", secretFile,
+		"MAX_BODY_BYTES", "2048",
+	)
+	rec := serve(newTestHandler(cfg), "GET", "/admin/config", "", "Authorization", "Bearer s3cret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	for _, secret := range []string{"hunter2", "hunter3", "k3ypass", "s3cret", "m3trics", "from-a-file"} {
+		if strings.Contains(rec.Body.String(), secret) {
+			t.Errorf("config report leaks %q: %s", secret, rec.Body)
+		}
+	}
+
+	var report map[string]configValue
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]configValue{
+		"DATABASE_URL":      {Value: "postgres://app:***@db:5432/users", Source: "env"},
+		"ADMIN_TOKEN":       {Value: "***", Source: "env"},
+		"METRICS_PASSWORD":  {Value: "***", Source: "file"},
+		"MAX_BODY_BYTES":    {Value: float64(2048), Source: "env"},
+		"DATABASE_READ_URL": {Value: "postgres://app@replica:5432/users?password=***&sslmode=verify-full&sslpassword=***", Source: "env"},
+	} {
+		if got := report[key]; got != want {
+			t.Errorf("%s = %+v, want %+v", key, got, want)
+		}
+	}
+}
+
+func TestRedactSecret(t *testing.T) {
+	for _, tc := range []struct{ key, value, want string }{
+		{"ADMIN_TOKEN", "", ""},
+		{"ADMIN_TOKEN", "s3cret", "***"},
+		{"DATABASE_URL", "postgres://app:pw@db/app?sslmode=disable", "postgres://app:***@db/app?sslmode=disable"},
+		{"DATABASE_URL", "postgres://app@db/app?password=pw", "postgres://app@db/app?password=***"},
+		{"DATABASE_URL", "postgres://app@db/app?sslkey=%2Fkeys%2Fclient.key&sslmode=verify-full", "postgres://app@db/app?sslkey=***&sslmode=verify-full"},
+		{"DATABASE_URL", "host=db password=pw", "***"}, // not a URL: hidden entirely
+	} {
+		if got := redactSecret(tc.key, tc.value); got != tc.want {
+			t.Errorf("redactSecret(%s, %q) = %q, want %q", tc.key, tc.value, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// every runs fn once per interval until ctx is cancelled. The returned
+// channel is closed once the goroutine has stopped, so shutdown can wait for
+// it. A non-positive interval disables the job.
+func every(ctx context.Context, interval time.Duration, fn func(ctx context.Context)) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if interval <= 0 {
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fn(ctx)
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sony/gobreaker"
+)
+
+// dbBreaker trips after DB_BREAKER_FAILURES consecutive connection failures
+// and fast-fails store queries for DB_BREAKER_COOLDOWN, after which a single
+// probe query decides whether it closes again. nil means disabled.
+var dbBreaker *gobreaker.CircuitBreaker
+
+// breakerReopensAt is when an open breaker lets its next probe query
+// through (Unix nanoseconds), so 503s can say how long to back off
+var breakerReopensAt atomic.Int64
+
+// dbBreakerState mirrors the breaker: 0 = closed, 1 = half-open, 2 = open
+var dbBreakerState = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "db_circuit_breaker_state",
+	Help: "Database circuit breaker state (0 = closed, 1 = half-open, 2 = open).",
+})
+
+// newDBBreaker builds the breaker from the config (nil when failures is 0)
+func newDBBreaker(failures uint32, cooldown time.Duration) *gobreaker.CircuitBreaker {
+	if failures == 0 {
+		return nil
+	}
+	return gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:    "database",
+		Timeout: cooldown,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= failures
+		},
+		// 👇 Only an unhealthy database should trip the breaker; missing
+		// rows, constraint violations and cancelled requests are normal
+		IsSuccessful: func(err error) bool {
+			return err == nil || !(isConnectionError(err) || errors.Is(err, context.DeadlineExceeded))
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			dbBreakerState.Set(float64(to))
+			switch to {
+			case gobreaker.StateOpen:
+				breakerReopensAt.Store(time.Now().Add(cooldown).UnixNano())
+				log.Printf("🛑 Database circuit breaker opened, failing fast for %s\n", cooldown)
+			case gobreaker.StateClosed:
+				log.Println("✅ Database circuit breaker closed")
+			}
+		},
+	})
+}
+
+// guard runs fn through the breaker, if one is configured
+func guard(fn func() error) error {
+	if dbBreaker == nil {
+		return fn()
+	}
+	_, err := dbBreaker.Execute(func() (interface{}, error) {
+		return nil, fn()
+	})
+	return err
+}
+
+// breakerRetryAfter is the Retry-After (seconds) for a query the breaker
+// refused: the rest of its cooldown, at least a second
+func breakerRetryAfter() string {
+	wait := time.Until(time.Unix(0, breakerReopensAt.Load()))
+	return strconv.Itoa(max(1, int(math.Ceil(wait.Seconds()))))
+}
+
+// isBreakerOpen reports whether err is the breaker refusing to run a query
+func isBreakerOpen(err error) bool {
+	return errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests)
+}
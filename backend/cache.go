@@ -0,0 +1,168 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Cache counters published at /debug/vars
+var (
+	cacheHits   = expvar.NewInt("cache_hits")
+	cacheMisses = expvar.NewInt("cache_misses")
+)
+
+// lruCache is a size-bounded LRU map whose entries also expire after ttl
+type lruCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	order   *list.List // front = most recently used
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func newLRUCache(size int, ttl time.Duration) *lruCache {
+	return &lruCache{ttl: ttl, size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Get returns the live value for key, dropping it if it has expired
+func (c *lruCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true
+}
+
+// Set stores value under key, evicting the least recently used entry when full
+func (c *lruCache) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		el.Value = &cacheEntry{key: key, value: value, expires: expires}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value, expires: expires})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Purge drops every entry
+func (c *lruCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	clear(c.entries)
+}
+
+// cachedStore caches List and Count results in front of another UserStore,
+// keyed by the normalized list options. Any write purges the whole cache:
+// one created or renamed user can move every page, so working out which
+// entries it touched isn't worth it. Other replicas' writes are only picked
+// up when entries expire.
+type cachedStore struct {
+	UserStore
+	cache *lruCache
+}
+
+// listCacheKey normalizes opts so equal queries share an entry
+func listCacheKey(opts ListOptions) string {
+	return fmt.Sprintf("list limit=%d offset=%d sort=%v fields=%v", opts.Limit, opts.Offset, opts.Sort, opts.Fields)
+}
+
+func (s *cachedStore) List(ctx context.Context, opts ListOptions) ([]User, error) {
+	key := listCacheKey(opts)
+	if v, ok := s.cache.Get(key); ok {
+		cacheHits.Add(1)
+		return v.([]User), nil
+	}
+	cacheMisses.Add(1)
+
+	users, err := s.UserStore.List(ctx, opts)
+	if err == nil {
+		s.cache.Set(key, users)
+	}
+	return users, err
+}
+
+func (s *cachedStore) Count(ctx context.Context) (int, error) {
+	if v, ok := s.cache.Get("count"); ok {
+		cacheHits.Add(1)
+		return v.(int), nil
+	}
+	cacheMisses.Add(1)
+
+	n, err := s.UserStore.Count(ctx)
+	if err == nil {
+		s.cache.Set("count", n)
+	}
+	return n, err
+}
+
+// 👇 Every write purges after it runs (even if it failed: it may still have
+// been applied)
+
+func (s *cachedStore) Create(ctx context.Context, name string) (User, error) {
+	defer s.cache.Purge()
+	return s.UserStore.Create(ctx, name)
+}
+
+func (s *cachedStore) Update(ctx context.Context, id int, name string) (User, error) {
+	defer s.cache.Purge()
+	return s.UserStore.Update(ctx, id, name)
+}
+
+func (s *cachedStore) UpdateIfUnmodified(ctx context.Context, id int, name string, updatedAt time.Time) (User, error) {
+	defer s.cache.Purge()
+	return s.UserStore.UpdateIfUnmodified(ctx, id, name, updatedAt)
+}
+
+func (s *cachedStore) Delete(ctx context.Context, id int) error {
+	defer s.cache.Purge()
+	return s.UserStore.Delete(ctx, id)
+}
+
+func (s *cachedStore) RenameMany(ctx context.Context, renames []Rename, partial bool) ([]RenameResult, error) {
+	defer s.cache.Purge()
+	return s.UserStore.RenameMany(ctx, renames, partial)
+}
+
+func (s *cachedStore) RenameAll(ctx context.Context, ids []int, name string) (int64, error) {
+	defer s.cache.Purge()
+	return s.UserStore.RenameAll(ctx, ids, name)
+}
+
+func (s *cachedStore) UpsertByName(ctx context.Context, name string) (User, bool, error) {
+	defer s.cache.Purge()
+	return s.UserStore.UpsertByName(ctx, name)
+}
+
+func (s *cachedStore) SetCreatedAt(ctx context.Context, id int, createdAt time.Time) (User, error) {
+	defer s.cache.Purge()
+	return s.UserStore.SetCreatedAt(ctx, id, createdAt)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// countingStore counts the List calls that reach the store behind the cache
+type countingStore struct {
+	*fakeStore
+	lists int
+}
+
+func (s *countingStore) List(ctx context.Context, opts ListOptions) ([]User, error) {
+	s.lists++
+	return s.fakeStore.List(ctx, opts)
+}
+
+func newCountingCache(t *testing.T, size int, ttl time.Duration) (*cachedStore, *countingStore) {
+	t.Helper()
+	cs := &countingStore{fakeStore: useFakeStore(t, "Ada", "Grace", "Linus")}
+	return &cachedStore{UserStore: cs, cache: newLRUCache(size, ttl)}, cs
+}
+
+func TestCacheKeyedByListOptions(t *testing.T) {
+	s, cs := newCountingCache(t, 10, time.Minute)
+	ctx := context.Background()
+	first := ListOptions{Limit: 1}
+	second := ListOptions{Limit: 1, Offset: 1, Sort: []SortField{{Column: "name", Desc: true}}}
+
+	a, _ := s.List(ctx, first)
+	b, _ := s.List(ctx, second)
+	if cs.lists != 2 {
+		t.Fatalf("%d store calls for two different queries, want 2", cs.lists)
+	}
+	if a[0].Name != "Ada" || b[0].Name != "Grace" {
+		t.Errorf("pages = %v, %v; want Ada, Grace", a, b)
+	}
+
+	a2, _ := s.List(ctx, first)
+	b2, _ := s.List(ctx, ListOptions{Limit: 1, Offset: 1, Sort: []SortField{{Column: "name", Desc: true}}})
+	if cs.lists != 2 {
+		t.Errorf("%d store calls after repeating both queries, want 2", cs.lists)
+	}
+	if a2[0].Name != "Ada" || b2[0].Name != "Grace" {
+		t.Errorf("cached pages = %v, %v; want Ada, Grace", a2, b2)
+	}
+}
+
+func TestCacheWritesPurgeEveryEntry(t *testing.T) {
+	s, cs := newCountingCache(t, 10, time.Minute)
+	ctx := context.Background()
+	s.List(ctx, ListOptions{})
+	s.List(ctx, ListOptions{Limit: 2})
+
+	s.Create(ctx, "Ken")
+	all, _ := s.List(ctx, ListOptions{})
+	s.List(ctx, ListOptions{Limit: 2})
+	if cs.lists != 4 {
+		t.Errorf("%d store calls, want both queries re-run after a write", cs.lists)
+	}
+	if len(all) != 4 {
+		t.Errorf("listed %d users after the create, want 4", len(all))
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	s, cs := newCountingCache(t, 2, time.Minute)
+	ctx := context.Background()
+	s.List(ctx, ListOptions{Limit: 1})
+	s.List(ctx, ListOptions{Limit: 2})
+	s.List(ctx, ListOptions{Limit: 1}) // now the most recently used
+	s.List(ctx, ListOptions{Limit: 3}) // evicts Limit: 2
+
+	cs.lists = 0
+	s.List(ctx, ListOptions{Limit: 1})
+	s.List(ctx, ListOptions{Limit: 3})
+	if cs.lists != 0 {
+		t.Errorf("%d store calls for entries that should still be cached", cs.lists)
+	}
+	s.List(ctx, ListOptions{Limit: 2})
+	if cs.lists != 1 {
+		t.Error("the least recently used entry was not evicted")
+	}
+}
+
+func TestCacheEntriesExpire(t *testing.T) {
+	s, cs := newCountingCache(t, 10, 20*time.Millisecond)
+	ctx := context.Background()
+	s.List(ctx, ListOptions{})
+	s.List(ctx, ListOptions{})
+	time.Sleep(30 * time.Millisecond)
+	s.List(ctx, ListOptions{})
+	if cs.lists != 2 {
+		t.Errorf("%d store calls, want one before and one after the ttl", cs.lists)
+	}
+}
@@ -0,0 +1,730 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings the backend reads from its environment. The env
+// tag names the variable behind each field (used by /debug/config) and
+// secret fields are redacted there.
+type Config struct {
+	Port string `env:"PORT"`
+
+	// DatabaseURL, when set, replaces the individual DB_* settings below
+	DatabaseURL string `env:"DATABASE_URL" secret:"true"`
+
+	// DatabaseReadURL, when set, points reads at a replica (same format as
+	// DatabaseURL); writes always use the primary
+	DatabaseReadURL string `env:"DATABASE_READ_URL" secret:"true"`
+
+	// Database connection info
+	// 👇 These come from our Secret and ConfigMap!
+	DBHost     string `env:"DB_HOST"`
+	DBUser     string `env:"POSTGRES_USER"`
+	DBPassword string `env:"POSTGRES_PASSWORD" secret:"true"`
+	DBName     string `env:"POSTGRES_DB"`
+	DBSchema   string `env:"DB_SCHEMA"`
+
+	// DevMode fills unset DB_HOST/POSTGRES_* with local defaults (see
+	// devDBDefaults); otherwise they are required unless DATABASE_URL is set
+	DevMode bool `env:"DEV_MODE"`
+
+	// DBTokenFile holds a short-lived password (e.g. an RDS IAM token) that
+	// is re-read for every new connection instead of DBPassword
+	DBTokenFile string `env:"DB_TOKEN_FILE"`
+
+	// MigrateOnStart applies pending migrations at startup; without it they
+	// wait for POST /admin/migrate
+	MigrateOnStart bool `env:"MIGRATE_ON_START"`
+
+	// SeedData inserts the demo users on startup; SeedFile optionally
+	// replaces the built-in names with a JSON array of names, and SeedCount
+	// pads the list with synthetic users up to that many
+	SeedData  bool   `env:"SEED_DATA"`
+	SeedFile  string `env:"SEED_FILE"`
+	SeedCount int    `env:"SEED_COUNT"`
+
+	// Connection pool hygiene: recycle connections after a lifetime or idle
+	// period, and ping the pool in the background every DBPingInterval
+	DBConnMaxLifetime time.Duration `env:"DB_CONN_MAX_LIFETIME"`
+	DBConnMaxIdleTime time.Duration `env:"DB_CONN_MAX_IDLE_TIME"`
+	DBPingInterval    time.Duration `env:"DB_PING_INTERVAL"`
+
+	// DBMaxOpenConns caps the pool (0 = unlimited). Store queries wait at
+	// most DBAcquireTimeout for a connection and then run for at most
+	// DBQueryTimeout (0 = no limit beyond the request's).
+	DBMaxOpenConns   int           `env:"DB_MAX_OPEN_CONNS"`
+	DBAcquireTimeout time.Duration `env:"DB_ACQUIRE_TIMEOUT"`
+	DBQueryTimeout   time.Duration `env:"DB_QUERY_TIMEOUT"`
+
+	// DBQueryTags prefixes store queries with a /* op=... */ comment
+	DBQueryTags bool `env:"DB_QUERY_TAGS"`
+
+	// DBConnectTimeout bounds each new connection attempt, in whole seconds
+	// (0 = wait as long as the OS does)
+	DBConnectTimeout int `env:"DB_CONNECT_TIMEOUT"`
+
+	// DBRetryReads retries read-only queries once after a dropped connection
+	DBRetryReads bool `env:"DB_RETRY_READS"`
+
+	// The circuit breaker opens after DBBreakerFailures consecutive
+	// connection failures (0 = disabled) and fails fast for DBBreakerCooldown
+	DBBreakerFailures uint32        `env:"DB_BREAKER_FAILURES"`
+	DBBreakerCooldown time.Duration `env:"DB_BREAKER_COOLDOWN"`
+
+	// UsersCacheTTL caches list results in memory for that long (0 = off),
+	// keeping at most UsersCacheSize distinct queries
+	UsersCacheTTL  time.Duration `env:"USERS_CACHE_TTL"`
+	UsersCacheSize int           `env:"USERS_CACHE_SIZE"`
+
+	// UsersGaugeInterval is how often users_total is recounted from the DB
+	UsersGaugeInterval time.Duration `env:"USERS_GAUGE_INTERVAL"`
+
+	// StatsdAddr, when set, is the StatsD/Datadog agent (host:port) we push
+	// metrics to every StatsdInterval, each name starting with StatsdPrefix
+	StatsdAddr     string        `env:"STATSD_ADDR"`
+	StatsdPrefix   string        `env:"STATSD_PREFIX"`
+	StatsdInterval time.Duration `env:"STATSD_INTERVAL"`
+
+	// Server-side limits applied to every connection (0 = Postgres default)
+	StatementTimeout time.Duration `env:"DB_STATEMENT_TIMEOUT"`
+	LockTimeout      time.Duration `env:"DB_LOCK_TIMEOUT"`
+
+	// PageSizeDefault is the page size when a list request has no limit;
+	// larger limits are clamped to PageSizeMax, or rejected with
+	// StrictPagination
+	PageSizeDefault  int  `env:"PAGE_SIZE_DEFAULT"`
+	PageSizeMax      int  `env:"PAGE_SIZE_MAX"`
+	StrictPagination bool `env:"STRICT_PAGINATION"`
+
+	// JSONNaming is "snake" (created_at) or "camel" (createdAt)
+	JSONNaming string `env:"JSON_NAMING"`
+
+	// TimeFormat encodes user timestamps as "rfc3339", "unix" (seconds) or
+	// "unixmilli"
+	TimeFormat string `env:"TIME_FORMAT"`
+
+	// PrettyJSON indents responses unless a request asks for ?pretty=false
+	PrettyJSON bool `env:"PRETTY_JSON"`
+
+	// EnvelopeResponses wraps every successful response in {"data", "meta"}
+	EnvelopeResponses bool `env:"ENVELOPE_RESPONSES"`
+
+	// FeatureFlags are the flag defaults from FEATURE_FLAGS; overrides are
+	// re-read from the database every FlagsRefreshInterval
+	FeatureFlags         map[string]bool `env:"FEATURE_FLAGS"`
+	FlagsRefreshInterval time.Duration   `env:"FLAGS_REFRESH_INTERVAL"`
+
+	// MaintenanceMode starts the server rejecting writes (toggle at runtime
+	// via /admin/maintenance)
+	MaintenanceMode bool `env:"MAINTENANCE_MODE"`
+
+	// ReadOnly rejects writes for as long as the process runs
+	ReadOnly bool `env:"READ_ONLY"`
+
+	// AdminToken guards the /admin/* endpoints (unset = they aren't served)
+	AdminToken string `env:"ADMIN_TOKEN" secret:"true"`
+
+	// SchemaRequireAdmin puts GET /api/schema behind the admin token
+	SchemaRequireAdmin bool `env:"SCHEMA_REQUIRE_ADMIN"`
+
+	// Optional credentials for /metrics (all unset = open)
+	MetricsToken    string `env:"METRICS_TOKEN" secret:"true"`
+	MetricsUser     string `env:"METRICS_USER"`
+	MetricsPassword string `env:"METRICS_PASSWORD" secret:"true"`
+
+	// Debug enables the /debug/* endpoints; DebugConfig additionally serves
+	// /debug/config (behind the admin token)
+	Debug       bool `env:"DEBUG"`
+	DebugConfig bool `env:"DEBUG_CONFIG"`
+
+	// MaxRequestDuration is the hard limit for handling any request (0 = none)
+	MaxRequestDuration time.Duration `env:"MAX_REQUEST_DURATION"`
+
+	// RouteTimeouts overrides MaxRequestDuration per route pattern, e.g.
+	// "GET /api/users=3s,GET /api/users/extremes=10s"
+	RouteTimeouts map[string]time.Duration `env:"ROUTE_TIMEOUTS"`
+
+	// MaxBodyBytes caps request bodies (0 = no limit), and RouteBodyLimits
+	// overrides it per route pattern, e.g. "PATCH /api/users/bulk=10485760"
+	MaxBodyBytes    int64            `env:"MAX_BODY_BYTES"`
+	RouteBodyLimits map[string]int64 `env:"ROUTE_BODY_LIMITS"`
+
+	// TrustedProxies are the proxies (e.g. the ingress) whose
+	// X-Forwarded-For and X-Real-IP headers we believe; empty = none
+	TrustedProxies []netip.Prefix `env:"TRUST_PROXY"`
+
+	// AppEnv names the environment; anything but "production" enables the
+	// test-only endpoints. Unset means production, so they are opt-in.
+	AppEnv string `env:"APP_ENV"`
+
+	// BasePath prefixes every route, for ingresses that forward /backend/*
+	// without stripping it
+	BasePath string `env:"BASE_PATH"`
+
+	// HealthPath and ReadyPath are where the liveness and readiness probes
+	// are served
+	HealthPath string `env:"HEALTH_PATH"`
+	ReadyPath  string `env:"READY_PATH"`
+
+	// ReadyCheckTimeout bounds each dependency check run by the readiness probe
+	ReadyCheckTimeout time.Duration `env:"READY_CHECK_TIMEOUT"`
+
+	// ReadyCheckCacheTTL is how long a passing database check is reused by
+	// later readiness probes (0 = check on every probe)
+	ReadyCheckCacheTTL time.Duration `env:"READY_CHECK_CACHE_TTL"`
+
+	// ReadyCheckTempDir adds a (non-critical) temp-file writability check to
+	// the readiness probe
+	ReadyCheckTempDir bool `env:"READY_CHECK_TEMP_DIR"`
+
+	// ReadyRequireMigrations makes pending migrations fail the readiness
+	// probe instead of only degrading it
+	ReadyRequireMigrations bool `env:"READY_REQUIRE_MIGRATIONS"`
+
+	// ReadyFailureThreshold is how many probes in a row must see a critical
+	// dependency down before we report unready
+	ReadyFailureThreshold int `env:"READY_FAILURE_THRESHOLD"`
+
+	// ShutdownDelay keeps serving (while unready) after SIGTERM, so endpoints
+	// are updated before we stop accepting connections
+	ShutdownDelay time.Duration `env:"SHUTDOWN_DELAY"`
+
+	// CORSAllowedOrigins may call the API from a browser ("*" = any; unset =
+	// CORS is off). CORSAllowCredentials lets them send cookies and auth
+	// headers, CORSMaxAge is how long preflights are cached and
+	// CORSExposedHeaders are the response headers scripts may read.
+	CORSAllowedOrigins   []string      `env:"CORS_ALLOWED_ORIGINS"`
+	CORSAllowCredentials bool          `env:"CORS_ALLOW_CREDENTIALS"`
+	CORSMaxAge           time.Duration `env:"CORS_MAX_AGE"`
+	CORSExposedHeaders   []string      `env:"CORS_EXPOSED_HEADERS"`
+
+	// ShutdownTimeout bounds how long we wait for in-flight requests to drain
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT"`
+}
+
+// identifierPattern matches plain, unquoted Postgres identifiers
+var identifierPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// probePathPattern matches a plain URL path we can register a probe (or
+// BASE_PATH) at
+var probePathPattern = regexp.MustCompile(`^(/[A-Za-z0-9._-]+)+$`)
+
+// reservedPaths are served by the app itself, along with everything below
+// them, so the probes can't be moved there (registering a second handler
+// for a route panics at startup)
+var reservedPaths = []string{"/version", "/stats", "/metrics", "/api", "/admin", "/debug"}
+
+// isReservedPath reports whether path is, or is under, one of reservedPaths
+func isReservedPath(path string) bool {
+	for _, p := range reservedPaths {
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfig reads the Config from environment variables, applying defaults
+func loadConfig() (Config, error) {
+	cfg := Config{
+		Port:        getEnv("PORT", "3000"),
+		DBHost:      os.Getenv("DB_HOST"),
+		DBUser:      os.Getenv("POSTGRES_USER"),
+		DBName:      os.Getenv("POSTGRES_DB"),
+		DBSchema:    getEnv("DB_SCHEMA", "public"),
+		DBTokenFile: os.Getenv("DB_TOKEN_FILE"),
+		SeedFile:    os.Getenv("SEED_FILE"),
+		MetricsUser: os.Getenv("METRICS_USER"),
+	}
+
+	var err error
+	secrets := []struct {
+		key string
+		dst *string
+	}{
+		{"DATABASE_URL", &cfg.DatabaseURL},
+		{"DATABASE_READ_URL", &cfg.DatabaseReadURL},
+		{"POSTGRES_PASSWORD", &cfg.DBPassword},
+		{"ADMIN_TOKEN", &cfg.AdminToken},
+		{"METRICS_TOKEN", &cfg.MetricsToken},
+		{"METRICS_PASSWORD", &cfg.MetricsPassword},
+	}
+	for _, s := range secrets {
+		if *s.dst, err = getSecret(s.key); err != nil {
+			return cfg, err
+		}
+	}
+	if cfg.DevMode, err = getEnvBool("DEV_MODE", false); err != nil {
+		return cfg, err
+	}
+	if cfg.DatabaseURL == "" {
+		if err := checkDBVars(&cfg); err != nil {
+			return cfg, err
+		}
+	}
+	if cfg.MetricsUser != "" && cfg.MetricsPassword == "" {
+		return cfg, errors.New("METRICS_PASSWORD is required when METRICS_USER is set")
+	}
+	if cfg.SchemaRequireAdmin, err = getEnvBool("SCHEMA_REQUIRE_ADMIN", false); err != nil {
+		return cfg, err
+	}
+	if cfg.SchemaRequireAdmin && cfg.AdminToken == "" {
+		return cfg, errors.New("ADMIN_TOKEN is required when SCHEMA_REQUIRE_ADMIN is set")
+	}
+	if !identifierPattern.MatchString(cfg.DBSchema) {
+		return cfg, fmt.Errorf("invalid DB_SCHEMA %q: must be a lowercase identifier", cfg.DBSchema)
+	}
+	cfg.AppEnv = getEnv("APP_ENV", "production")
+	cfg.BasePath = strings.TrimSuffix(os.Getenv("BASE_PATH"), "/")
+	if cfg.BasePath != "" && !probePathPattern.MatchString(cfg.BasePath) {
+		return cfg, fmt.Errorf("invalid BASE_PATH %q: must be a path like /backend", cfg.BasePath)
+	}
+	cfg.HealthPath = getEnv("HEALTH_PATH", "/health")
+	cfg.ReadyPath = getEnv("READY_PATH", "/readyz")
+	for key, path := range map[string]string{"HEALTH_PATH": cfg.HealthPath, "READY_PATH": cfg.ReadyPath} {
+		if !probePathPattern.MatchString(path) {
+			return cfg, fmt.Errorf("invalid %s %q: must be a path like /healthz", key, path)
+		}
+		if isReservedPath(path) {
+			return cfg, fmt.Errorf("invalid %s %q: already served by the app (reserved: %s)", key, path, strings.Join(reservedPaths, ", "))
+		}
+	}
+	if cfg.HealthPath == cfg.ReadyPath {
+		return cfg, errors.New("HEALTH_PATH and READY_PATH must differ")
+	}
+	cfg.JSONNaming = getEnv("JSON_NAMING", "snake")
+	if cfg.JSONNaming != "snake" && cfg.JSONNaming != "camel" {
+		return cfg, fmt.Errorf("invalid JSON_NAMING %q: must be snake or camel", cfg.JSONNaming)
+	}
+	cfg.TimeFormat = getEnv("TIME_FORMAT", "rfc3339")
+	if cfg.TimeFormat != "rfc3339" && cfg.TimeFormat != "unix" && cfg.TimeFormat != "unixmilli" {
+		return cfg, fmt.Errorf("invalid TIME_FORMAT %q: must be rfc3339, unix or unixmilli", cfg.TimeFormat)
+	}
+	if cfg.PrettyJSON, err = getEnvBool("PRETTY_JSON", false); err != nil {
+		return cfg, err
+	}
+	if cfg.EnvelopeResponses, err = getEnvBool("ENVELOPE_RESPONSES", false); err != nil {
+		return cfg, err
+	}
+	if cfg.PageSizeDefault, err = getEnvInt("PAGE_SIZE_DEFAULT", 100); err != nil {
+		return cfg, err
+	}
+	if cfg.PageSizeMax, err = getEnvInt("PAGE_SIZE_MAX", 1000); err != nil {
+		return cfg, err
+	}
+	if cfg.PageSizeDefault > cfg.PageSizeMax {
+		return cfg, fmt.Errorf("PAGE_SIZE_DEFAULT (%d) must not exceed PAGE_SIZE_MAX (%d)", cfg.PageSizeDefault, cfg.PageSizeMax)
+	}
+	if cfg.StrictPagination, err = getEnvBool("STRICT_PAGINATION", false); err != nil {
+		return cfg, err
+	}
+	if cfg.FeatureFlags, err = parseFlags(os.Getenv("FEATURE_FLAGS")); err != nil {
+		return cfg, err
+	}
+	if cfg.FlagsRefreshInterval, err = getEnvDuration("FLAGS_REFRESH_INTERVAL", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.Debug, err = getEnvBool("DEBUG", false); err != nil {
+		return cfg, err
+	}
+	if cfg.DebugConfig, err = getEnvBool("DEBUG_CONFIG", false); err != nil {
+		return cfg, err
+	}
+	if cfg.SeedData, err = getEnvBool("SEED_DATA", false); err != nil {
+		return cfg, err
+	}
+	if cfg.SeedCount, err = getEnvInt("SEED_COUNT", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.DBConnMaxLifetime, err = getEnvDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.DBConnMaxIdleTime, err = getEnvDuration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.DBPingInterval, err = getEnvDuration("DB_PING_INTERVAL", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.DBMaxOpenConns, err = getEnvNonNegInt("DB_MAX_OPEN_CONNS", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.DBMaxOpenConns == 1 {
+		// initDatabase holds one connection for its lock while migrating on another
+		return cfg, errors.New("DB_MAX_OPEN_CONNS must be at least 2")
+	}
+	if cfg.DBAcquireTimeout, err = getEnvDuration("DB_ACQUIRE_TIMEOUT", 5*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.DBQueryTimeout, err = getEnvDuration("DB_QUERY_TIMEOUT", 10*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.DBRetryReads, err = getEnvBool("DB_RETRY_READS", false); err != nil {
+		return cfg, err
+	}
+	if cfg.DBQueryTags, err = getEnvBool("DB_QUERY_TAGS", false); err != nil {
+		return cfg, err
+	}
+	if cfg.MigrateOnStart, err = getEnvBool("MIGRATE_ON_START", true); err != nil {
+		return cfg, err
+	}
+	if cfg.DBConnectTimeout, err = getEnvNonNegInt("DB_CONNECT_TIMEOUT", 10); err != nil {
+		return cfg, err
+	}
+	if cfg.DBBreakerFailures, err = getEnvUint32("DB_BREAKER_FAILURES", 5); err != nil {
+		return cfg, err
+	}
+	if cfg.DBBreakerCooldown, err = getEnvDuration("DB_BREAKER_COOLDOWN", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.UsersCacheTTL, err = getEnvDuration("USERS_CACHE_TTL", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.UsersCacheSize, err = getEnvInt("USERS_CACHE_SIZE", 100); err != nil {
+		return cfg, err
+	}
+	if cfg.UsersGaugeInterval, err = getEnvDuration("USERS_GAUGE_INTERVAL", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	cfg.StatsdAddr = os.Getenv("STATSD_ADDR")
+	cfg.StatsdPrefix = getEnv("STATSD_PREFIX", "backend.")
+	if cfg.StatsdInterval, err = getEnvDuration("STATSD_INTERVAL", 10*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.StatementTimeout, err = getEnvDuration("DB_STATEMENT_TIMEOUT", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.LockTimeout, err = getEnvDuration("DB_LOCK_TIMEOUT", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxRequestDuration, err = getEnvDuration("MAX_REQUEST_DURATION", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.RouteTimeouts, err = parseRouteValues("ROUTE_TIMEOUTS", "3s", time.ParseDuration); err != nil {
+		return cfg, err
+	}
+	maxBodyBytes, err := getEnvNonNegInt("MAX_BODY_BYTES", 1<<20)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.MaxBodyBytes = int64(maxBodyBytes)
+	parseBytes := func(v string) (int64, error) { return strconv.ParseInt(v, 10, 64) }
+	if cfg.RouteBodyLimits, err = parseRouteValues("ROUTE_BODY_LIMITS", "1048576", parseBytes); err != nil {
+		return cfg, err
+	}
+	if cfg.ReadyCheckTimeout, err = getEnvDuration("READY_CHECK_TIMEOUT", 2*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.ReadyCheckCacheTTL, err = getEnvDuration("READY_CHECK_CACHE_TTL", time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.ReadyCheckTempDir, err = getEnvBool("READY_CHECK_TEMP_DIR", false); err != nil {
+		return cfg, err
+	}
+	if cfg.ReadyRequireMigrations, err = getEnvBool("READY_REQUIRE_MIGRATIONS", false); err != nil {
+		return cfg, err
+	}
+	if cfg.ReadyFailureThreshold, err = getEnvInt("READY_FAILURE_THRESHOLD", 1); err != nil {
+		return cfg, err
+	}
+	if cfg.ShutdownDelay, err = getEnvDuration("SHUTDOWN_DELAY", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.MaintenanceMode, err = getEnvBool("MAINTENANCE_MODE", false); err != nil {
+		return cfg, err
+	}
+	if cfg.ReadOnly, err = getEnvBool("READ_ONLY", false); err != nil {
+		return cfg, err
+	}
+	if cfg.ShutdownTimeout, err = getEnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second); err != nil {
+		return cfg, err
+	}
+	cfg.CORSAllowedOrigins = getEnvList("CORS_ALLOWED_ORIGINS", nil)
+	if cfg.CORSAllowCredentials, err = getEnvBool("CORS_ALLOW_CREDENTIALS", false); err != nil {
+		return cfg, err
+	}
+	for _, origin := range cfg.CORSAllowedOrigins {
+		if origin == "*" && cfg.CORSAllowCredentials {
+			return cfg, errors.New("CORS_ALLOW_CREDENTIALS can't be used with CORS_ALLOWED_ORIGINS=*: list the origins instead")
+		}
+		if u, err := url.Parse(origin); origin != "*" && (err != nil || u.Scheme == "" || u.Host == "" || u.Path != "") {
+			return cfg, fmt.Errorf("invalid CORS_ALLOWED_ORIGINS entry %q: must be * or an origin like https://app.example.com", origin)
+		}
+	}
+	if cfg.CORSMaxAge, err = getEnvDuration("CORS_MAX_AGE", 10*time.Minute); err != nil {
+		return cfg, err
+	}
+	for _, proxy := range getEnvList("TRUST_PROXY", nil) {
+		prefix, err := parsePrefix(proxy)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid TRUST_PROXY entry %q: must be an IP or CIDR like 10.0.0.0/8", proxy)
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, prefix)
+	}
+	cfg.CORSExposedHeaders = getEnvList("CORS_EXPOSED_HEADERS", []string{"ETag", "Link", "Location", "Retry-After", "X-Request-ID", "X-Total-Count"})
+
+	return cfg, nil
+}
+
+// devDBDefaults are what DEV_MODE uses for unset connection variables: a
+// stock Postgres on localhost
+var devDBDefaults = map[string]string{
+	"DB_HOST":           "localhost",
+	"POSTGRES_USER":     "postgres",
+	"POSTGRES_PASSWORD": "",
+	"POSTGRES_DB":       "postgres",
+}
+
+// checkDBVars makes sure every connection variable is set, filling the
+// missing ones from devDBDefaults in DEV_MODE. The password isn't needed
+// with DB_TOKEN_FILE.
+func checkDBVars(cfg *Config) error {
+	vars := []struct {
+		key   string
+		value *string
+	}{
+		{"DB_HOST", &cfg.DBHost},
+		{"POSTGRES_USER", &cfg.DBUser},
+		{"POSTGRES_PASSWORD", &cfg.DBPassword},
+		{"POSTGRES_DB", &cfg.DBName},
+	}
+
+	var missing []string
+	for _, v := range vars {
+		if *v.value != "" || (v.key == "POSTGRES_PASSWORD" && cfg.DBTokenFile != "") {
+			continue
+		}
+		if cfg.DevMode {
+			*v.value = devDBDefaults[v.key]
+			continue
+		}
+		missing = append(missing, v.key)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s must be set (or DATABASE_URL, or DEV_MODE=true for local defaults)", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// parsePrefix reads a CIDR, or a single IP as a one-address prefix
+func parsePrefix(v string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(v); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(v)
+	return prefix.Masked(), err
+}
+
+// parseRouteValues parses a per-route setting like ROUTE_TIMEOUTS from the
+// env var key: a comma-separated list of "METHOD /path=value" entries using
+// the patterns routes are registered with (main checks they exist). Values
+// are read with parse and must not be negative; example goes in the error.
+func parseRouteValues[T int64 | time.Duration](key, example string, parse func(string) (T, error)) (map[string]T, error) {
+	values := make(map[string]T)
+	for _, entry := range strings.Split(os.Getenv(key), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pattern, value, ok := strings.Cut(entry, "=")
+		method, path, hasPath := strings.Cut(strings.TrimSpace(pattern), " ")
+		n, err := parse(strings.TrimSpace(value))
+		if !ok || !hasPath || method == "" || !strings.HasPrefix(path, "/") || err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s entry %q: must look like GET /api/users=%s", key, entry, example)
+		}
+		values[method+" "+path] = n
+	}
+	return values, nil
+}
+
+// redacted replaces secret values in the config report
+const redacted = "***"
+
+// configValue is one entry of the config report
+type configValue struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"` // "env", "file" or "default"
+}
+
+// configReport describes the effective config keyed by env var, with secrets
+// redacted. A value's source is "env" when its variable is set, or "file"
+// when a secret comes from its _FILE variant.
+func configReport(cfg Config) map[string]configValue {
+	report := make(map[string]configValue)
+	v := reflect.ValueOf(cfg)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := field.Tag.Get("env")
+		if key == "" {
+			continue
+		}
+
+		var value interface{} = v.Field(i).Interface()
+		switch d := value.(type) {
+		case time.Duration:
+			value = d.String()
+		case map[string]time.Duration:
+			durations := make(map[string]string, len(d))
+			for k, v := range d {
+				durations[k] = v.String()
+			}
+			value = durations
+		}
+		if field.Tag.Get("secret") == "true" {
+			value = redactSecret(key, v.Field(i).String())
+		}
+
+		source := "default"
+		switch {
+		case field.Tag.Get("secret") == "true" && os.Getenv(key+"_FILE") != "":
+			source = "file"
+		case os.Getenv(key) != "":
+			source = "env"
+		}
+		report[key] = configValue{Value: value, Source: source}
+	}
+	return report
+}
+
+// secretURLParams are connection URL parameters lib/pq accepts secrets in
+var secretURLParams = []string{"password", "sslpassword", "sslkey"}
+
+// redactSecret hides a secret value, keeping an empty one empty so the report
+// still shows whether it was set. Database URLs keep everything but their
+// password, whether it is in the userinfo or a query parameter.
+func redactSecret(key, value string) string {
+	if value == "" {
+		return ""
+	}
+	if key == "DATABASE_URL" || key == "DATABASE_READ_URL" {
+		if u, err := url.Parse(value); err == nil && u.Host != "" {
+			q := u.Query()
+			for _, param := range secretURLParams {
+				if q.Has(param) {
+					q.Set(param, redacted)
+					u.RawQuery = q.Encode()
+				}
+			}
+			// Redacted writes "xxxxx"; swap in our marker without it being escaped
+			redactedURL := strings.Replace(u.Redacted(), ":xxxxx@", ":"+redacted+"@", 1)
+			return strings.ReplaceAll(redactedURL, url.QueryEscape(redacted), redacted)
+		}
+	}
+	return redacted
+}
+
+// getEnv returns the value of key, or fallback when it is unset or empty
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// getSecret returns the secret key, read from the file named by key_FILE
+// when that is set (how Docker and Kubernetes mount secrets, keeping them out
+// of the process environment), otherwise from key itself. The file wins over
+// the plain variable, minus its trailing newline.
+func getSecret(key string) (string, error) {
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		return os.Getenv(key), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading %s_FILE: %w", key, err)
+	}
+	v := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(v, "\r"), nil
+}
+
+// getEnvList splits key on commas, trimming spaces and dropping empty
+// entries, returning fallback when it is unset
+func getEnvList(key string, fallback []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvBool parses key as a boolean, returning fallback when it is unset
+func getEnvBool(key string, fallback bool) (bool, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fallback, fmt.Errorf("invalid %s %q: must be true or false", key, v)
+	}
+	return b, nil
+}
+
+// getEnvInt parses key as a positive integer, returning fallback when it is unset
+func getEnvInt(key string, fallback int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return fallback, fmt.Errorf("invalid %s %q: must be a positive integer", key, v)
+	}
+	return n, nil
+}
+
+// getEnvNonNegInt parses key as an integer where 0 is allowed (and usually
+// means "no limit"), returning fallback when it is unset
+func getEnvNonNegInt(key string, fallback int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return fallback, fmt.Errorf("invalid %s %q: must be a non-negative integer", key, v)
+	}
+	return n, nil
+}
+
+// getEnvUint32 parses key as a non-negative integer, returning fallback when it is unset
+func getEnvUint32(key string, fallback uint32) (uint32, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return fallback, fmt.Errorf("invalid %s %q: must be a non-negative integer", key, v)
+	}
+	return uint32(n), nil
+}
+
+// getEnvDuration parses key as a Go duration (e.g. "15s"), returning fallback when it is unset
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return fallback, fmt.Errorf("invalid %s %q: must be a positive duration like 15s", key, v)
+	}
+	return d, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// configError loads the config from env (key, value pairs) like testConfig,
+// returning the error instead of failing
+func configError(t *testing.T, env ...string) error {
+	t.Helper()
+	t.Setenv("DATABASE_URL", "postgres://test@localhost/test")
+	for i := 0; i+1 < len(env); i += 2 {
+		t.Setenv(env[i], env[i+1])
+	}
+	_, err := loadConfig()
+	return err
+}
+
+func TestDBMaxOpenConns(t *testing.T) {
+	if got := testConfig(t).DBMaxOpenConns; got != 0 {
+		t.Errorf("default DBMaxOpenConns = %d, want 0 (unlimited)", got)
+	}
+	for v, want := range map[string]int{"0": 0, "2": 2, "50": 50} {
+		if got := testConfig(t, "DB_MAX_OPEN_CONNS", v).DBMaxOpenConns; got != want {
+			t.Errorf("DB_MAX_OPEN_CONNS=%s: DBMaxOpenConns = %d, want %d", v, got, want)
+		}
+	}
+	for _, v := range []string{"1", "-1", "many"} {
+		if err := configError(t, "DB_MAX_OPEN_CONNS", v); err == nil {
+			t.Errorf("DB_MAX_OPEN_CONNS=%s: no error", v)
+		}
+	}
+}
+
+func TestDBConnectTimeout(t *testing.T) {
+	for v, want := range map[string]int{"": 10, "0": 0, "3": 3} {
+		if got := testConfig(t, "DB_CONNECT_TIMEOUT", v).DBConnectTimeout; got != want {
+			t.Errorf("DB_CONNECT_TIMEOUT=%q: DBConnectTimeout = %d, want %d", v, got, want)
+		}
+	}
+	for _, v := range []string{"-1", "1.5", "10s"} {
+		if err := configError(t, "DB_CONNECT_TIMEOUT", v); err == nil {
+			t.Errorf("DB_CONNECT_TIMEOUT=%s: no error", v)
+		}
+	}
+
+	// 0 leaves connect_timeout out of the connection string entirely
+	cfg := testConfig(t, "DB_CONNECT_TIMEOUT", "0")
+	connStr, err := buildConnStr(cfg)
+	if err != nil || strings.Contains(connStr, "connect_timeout") {
+		t.Errorf("buildConnStr = %q, %v; want no connect_timeout", connStr, err)
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	useFakeStore(t)
+	// A valid body padded past the 1 MiB default
+	body := `{"name": "Ada Lovelace"}` + strings.Repeat(" ", 2<<20)
+
+	h := newTestHandler(testConfig(t))
+	if rec := serve(h, "POST", "/api/users", body); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("default limit: status = %d, want 413", rec.Code)
+	}
+	h = newTestHandler(testConfig(t, "MAX_BODY_BYTES", "0"))
+	if rec := serve(h, "POST", "/api/users", body); rec.Code != http.StatusCreated {
+		t.Errorf("MAX_BODY_BYTES=0: status = %d, want 201: %s", rec.Code, rec.Body)
+	}
+	if err := configError(t, "MAX_BODY_BYTES", "-1"); err == nil {
+		t.Error("MAX_BODY_BYTES=-1: no error")
+	}
+}
+
+func TestSchemaRequireAdmin(t *testing.T) {
+	if err := configError(t, "SCHEMA_REQUIRE_ADMIN", "true"); err == nil {
+		t.Error("SCHEMA_REQUIRE_ADMIN without ADMIN_TOKEN: no error")
+	}
+
+	h := newTestHandler(testConfig(t, "SCHEMA_REQUIRE_ADMIN", "true", "ADMIN_TOKEN", "s3cret"))
+	for _, auth := range []string{"", "Bearer wrong"} {
+		if rec := serve(h, "GET", "/api/schema", "", "Authorization", auth); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want 401", auth, rec.Code)
+		}
+	}
+}
+
+func TestProbePaths(t *testing.T) {
+	cfg := testConfig(t, "HEALTH_PATH", "/livez", "READY_PATH", "/ready")
+	h := newTestHandler(cfg)
+	for _, path := range []string{"/livez", "/ready"} {
+		if rec := serve(h, "GET", path, ""); rec.Code == http.StatusNotFound {
+			t.Errorf("GET %s: 404", path)
+		}
+	}
+
+	for _, env := range [][]string{
+		{"HEALTH_PATH", "/version"},
+		{"READY_PATH", "/metrics"},
+		{"HEALTH_PATH", "/api/users"},
+		{"READY_PATH", "/admin/ready"},
+		{"HEALTH_PATH", "/debug"},
+		{"HEALTH_PATH", "health"},
+		{"HEALTH_PATH", "/same", "READY_PATH", "/same"},
+	} {
+		// 👇 A subtest each, so one case's variables don't leak into the next
+		t.Run(strings.Join(env, "="), func(t *testing.T) {
+			if err := configError(t, env...); err == nil {
+				t.Errorf("%v: no error", env)
+			}
+		})
+	}
+	// Paths that only share a prefix with a reserved one are fine
+	testConfig(t, "HEALTH_PATH", "/apihealth", "READY_PATH", "/versionz")
+}
+
+func TestDevModeDBDefaults(t *testing.T) {
+	// No DATABASE_URL, and nothing but the user name set
+	env := []string{"DATABASE_URL", "", "DB_HOST", "", "POSTGRES_USER", "dev", "POSTGRES_PASSWORD", "", "POSTGRES_DB", "", "DB_TOKEN_FILE", ""}
+
+	t.Run("production", func(t *testing.T) {
+		err := configError(t, env...)
+		if err == nil || !strings.Contains(err.Error(), "DB_HOST, POSTGRES_PASSWORD, POSTGRES_DB must be set") {
+			t.Errorf("err = %v, want the missing variables named", err)
+		}
+	})
+	t.Run("dev mode", func(t *testing.T) {
+		cfg := testConfig(t, append(env, "DEV_MODE", "true")...)
+		if cfg.DBHost != "localhost" || cfg.DBUser != "dev" || cfg.DBPassword != "" || cfg.DBName != "postgres" {
+			t.Errorf("got host=%q user=%q password=%q db=%q, want localhost defaults around the set user",
+				cfg.DBHost, cfg.DBUser, cfg.DBPassword, cfg.DBName)
+		}
+	})
+}
+
+func TestMigrateOnStart(t *testing.T) {
+	if !testConfig(t).MigrateOnStart {
+		t.Error("MIGRATE_ON_START is off by default")
+	}
+	if testConfig(t, "MIGRATE_ON_START", "false").MigrateOnStart {
+		t.Error("MIGRATE_ON_START=false was ignored")
+	}
+}
+
+func TestSecretFilesOverrideEnv(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	cfg := testConfig(t,
+		"DATABASE_URL", "",
+		"DB_HOST", "db", "POSTGRES_USER", "app", "POSTGRES_DB", "app",
+		"POSTGRES_PASSWORD", "from-env",
+		"POSTGRES_PASSWORD_FILE", write("password", "from-file\n"),
+		"ADMIN_TOKEN_FILE", write("token", "t0ken\r\n"),
+	)
+	if cfg.DBPassword != "from-file" {
+		t.Errorf("DBPassword = %q, want the file's value without its newline", cfg.DBPassword)
+	}
+	if cfg.AdminToken != "t0ken" {
+		t.Errorf("AdminToken = %q, want the file's value without its CRLF", cfg.AdminToken)
+	}
+
+	err := configError(t, "POSTGRES_PASSWORD_FILE", filepath.Join(dir, "missing"))
+	if err == nil || !strings.Contains(err.Error(), "POSTGRES_PASSWORD_FILE") {
+		t.Errorf("missing file: err = %v, want one naming POSTGRES_PASSWORD_FILE", err)
+	}
+}
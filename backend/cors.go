@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsAllowedMethods and corsAllowedHeaders are what a preflight may ask for
+const (
+	corsAllowedMethods = "GET, HEAD, POST, PUT, PATCH, DELETE"
+	corsAllowedHeaders = "Accept-Language, Authorization, Content-Type, If-Match, If-Modified-Since, X-Request-ID"
+)
+
+// corsPolicy is the CORS_* configuration
+type corsPolicy struct {
+	origins        map[string]bool
+	anyOrigin      bool
+	credentials    bool
+	maxAge         time.Duration
+	exposedHeaders string
+}
+
+func newCORSPolicy(cfg Config) corsPolicy {
+	p := corsPolicy{
+		origins:        make(map[string]bool),
+		credentials:    cfg.CORSAllowCredentials,
+		maxAge:         cfg.CORSMaxAge,
+		exposedHeaders: strings.Join(cfg.CORSExposedHeaders, ", "),
+	}
+	for _, origin := range cfg.CORSAllowedOrigins {
+		if origin == "*" {
+			p.anyOrigin = true
+		}
+		p.origins[origin] = true
+	}
+	return p
+}
+
+// withCORS lets browsers on the allowed origins call the API and answers
+// their preflight requests. With no origins configured it does nothing, so
+// the API stays same-origin only.
+func withCORS(next http.Handler, p corsPolicy) http.Handler {
+	if len(p.origins) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if !p.anyOrigin {
+			w.Header().Add("Vary", "Origin")
+		}
+		if origin == "" || !(p.anyOrigin || p.origins[origin]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		// 👇 loadConfig rejects credentials with "*", since browsers refuse
+		// a wildcard on credentialed requests
+		if p.anyOrigin {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if p.credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			if p.maxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.maxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if p.exposedHeaders != "" {
+			h.Set("Access-Control-Expose-Headers", p.exposedHeaders)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
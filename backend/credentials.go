@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// CredentialProvider supplies the database password for each new connection.
+// Managed databases with token auth (e.g. RDS IAM) hand out short-lived
+// passwords, so they can't be baked into the DSN once at startup. Without a
+// provider we keep using the password from the DSN.
+type CredentialProvider interface {
+	Password(ctx context.Context) (string, error)
+}
+
+// tokenFile re-reads the password from a file on every call, e.g. an IAM
+// auth token that a sidecar refreshes before it expires
+type tokenFile string
+
+func (p tokenFile) Password(ctx context.Context) (string, error) {
+	data, err := os.ReadFile(string(p))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// credentialConnector is a driver.Connector that asks its provider for a
+// fresh password every time the pool opens a new connection
+type credentialConnector struct {
+	dsn      string
+	provider CredentialProvider
+}
+
+func (c *credentialConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	password, err := c.provider.Password(ctx)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := pq.NewConnector(dsnWithPassword(c.dsn, password))
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (c *credentialConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// dsnWithPassword returns dsn (URL or key=value style) using password
+func dsnWithPassword(dsn, password string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		u.User = url.UserPassword(u.User.Username(), password)
+		return u.String()
+	}
+	// 👇 In key=value DSNs the last occurrence of a key wins
+	escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(password)
+	return dsn + " password='" + escaped + "'"
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stubProvider hands out a new token on every call
+type stubProvider struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *stubProvider) Password(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	return fmt.Sprintf("token-%d", p.calls), nil
+}
+
+func TestConnectorAsksForAFreshPasswordPerConnection(t *testing.T) {
+	provider := &stubProvider{}
+	// Nothing listens on port 1, so each attempt fails fast after asking
+	c := &credentialConnector{dsn: "host=127.0.0.1 port=1 user=app sslmode=disable connect_timeout=1", provider: provider}
+	for i := 0; i < 2; i++ {
+		c.Connect(context.Background())
+	}
+	if provider.calls != 2 {
+		t.Errorf("provider called %d times, want once per connection", provider.calls)
+	}
+}
+
+func TestDSNWithPassword(t *testing.T) {
+	for dsn, want := range map[string]string{
+		"postgres://app:old@db/app?sslmode=disable": "postgres://app:t%27k=n@db/app?sslmode=disable",
+		"host=db user=app password=old":             `host=db user=app password=old password='t\'k=n'`,
+	} {
+		if got := dsnWithPassword(dsn, "t'k=n"); got != want {
+			t.Errorf("dsnWithPassword(%q) = %q, want %q", dsn, got, want)
+		}
+	}
+}
+
+func TestTokenFileIsReRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	p := tokenFile(path)
+
+	for _, token := range []string{"first", "second"} {
+		os.WriteFile(path, []byte(token+"\n"), 0o600)
+		if got, err := p.Password(context.Background()); err != nil || got != token {
+			t.Errorf("Password() = %q, %v; want %q", got, err, token)
+		}
+	}
+	os.Remove(path)
+	if _, err := p.Password(context.Background()); err == nil || !strings.Contains(err.Error(), "token") {
+		t.Errorf("missing file: err = %v", err)
+	}
+}
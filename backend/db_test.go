@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestSessionTimeoutsInConnStr(t *testing.T) {
+	cfg := testConfig(t, "DB_STATEMENT_TIMEOUT", "2s", "DB_LOCK_TIMEOUT", "500ms")
+	connStr, err := buildConnStr(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// DATABASE_URL is set, so the options end up URL-encoded in the query
+	for _, want := range []string{"statement_timeout%3D2000", "lock_timeout%3D500"} {
+		if !strings.Contains(connStr, want) {
+			t.Errorf("connStr = %q, want it to contain %q", connStr, want)
+		}
+	}
+
+	if opts := sessionOptions(testConfig(t, "DB_STATEMENT_TIMEOUT", "0", "DB_LOCK_TIMEOUT", "0")); opts != "" {
+		t.Errorf("sessionOptions = %q, want none by default", opts)
+	}
+}
+
+func TestSchemaInConnStr(t *testing.T) {
+	if connStr, _ := buildConnStr(testConfig(t)); strings.Contains(connStr, "search_path") {
+		t.Errorf("connStr = %q, want the default search_path left alone", connStr)
+	}
+	connStr, err := buildConnStr(testConfig(t, "DB_SCHEMA", "tenant_a"))
+	if err != nil || !strings.Contains(connStr, "search_path%3Dtenant_a") {
+		t.Errorf("connStr = %q, %v; want search_path=tenant_a", connStr, err)
+	}
+	for _, schema := range []string{"Tenant", "tenant-a", "a;drop table users", "1st"} {
+		if err := configError(t, "DB_SCHEMA", schema); err == nil {
+			t.Errorf("DB_SCHEMA=%q: no error", schema)
+		}
+	}
+}
+
+func TestSeedingIsOptIn(t *testing.T) {
+	if testConfig(t).SeedData {
+		t.Error("SEED_DATA defaults to true, want fresh deployments left empty")
+	}
+}
+
+func TestLoadSeedNames(t *testing.T) {
+	if names, err := loadSeedNames(""); err != nil || !slices.Equal(names, seedUsers) {
+		t.Errorf("no SEED_FILE: %v, %v; want the built-in users", names, err)
+	}
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0o600)
+		return path
+	}
+	names, err := loadSeedNames(write("ok.json", `[" Ada ", "Grace"]`))
+	if err != nil || !slices.Equal(names, []string{"Ada", "Grace"}) {
+		t.Errorf("got %v, %v; want the trimmed names", names, err)
+	}
+	for _, content := range []string{`{"name": "Ada"}`, `["Ada", ""]`, `not json`} {
+		if _, err := loadSeedNames(write("bad.json", content)); err == nil {
+			t.Errorf("%s: no error", content)
+		}
+	}
+	if _, err := loadSeedNames(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing file: no error")
+	}
+}
+
+func TestPadSeedNames(t *testing.T) {
+	got := padSeedNames([]string{"Ada"}, 3)
+	if !slices.Equal(got, []string{"Ada", "User 2", "User 3"}) {
+		t.Errorf("padSeedNames = %v", got)
+	}
+	if got := padSeedNames([]string{"Ada", "Grace"}, 1); len(got) != 2 {
+		t.Errorf("padSeedNames = %v, want names never dropped", got)
+	}
+}
+
+func TestURLConnStrOptions(t *testing.T) {
+	cfg := testConfig(t)
+
+	// Defaults are added when missing
+	connStr, err := buildURLConnStr(cfg, "DATABASE_URL", "postgres://app@db/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(connStr)
+	if got := u.Query().Get("application_name"); got != applicationName() || !strings.HasPrefix(got, serviceName) {
+		t.Errorf("application_name = %q, want %q", got, applicationName())
+	}
+	if got := u.Query().Get("connect_timeout"); got != "10" {
+		t.Errorf("connect_timeout = %q, want the DB_CONNECT_TIMEOUT default", got)
+	}
+
+	// ...and the caller's own options are kept
+	connStr, err = buildURLConnStr(cfg, "DATABASE_URL", "postgresql://app@db/app?connect_timeout=5&application_name=reports&sslmode=require")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, _ := url.ParseQuery(connStr[strings.Index(connStr, "?")+1:])
+	if q.Get("connect_timeout") != "5" || q.Get("application_name") != "reports" || q.Get("sslmode") != "require" {
+		t.Errorf("connStr = %q, want the URL's options passed through", connStr)
+	}
+
+	for _, raw := range []string{"postgres://db/app?connect_timeout=soon", "mysql://db/app", "://"} {
+		if _, err := buildURLConnStr(cfg, "DATABASE_URL", raw); err == nil {
+			t.Errorf("%s: no error", raw)
+		}
+	}
+}
+
+func TestKeywordConnStrHasApplicationName(t *testing.T) {
+	cfg := testConfig(t, "DATABASE_URL", "", "DB_HOST", "db", "POSTGRES_USER", "app", "POSTGRES_PASSWORD", "pw", "POSTGRES_DB", "app")
+	connStr, err := buildConnStr(cfg)
+	if err != nil || !strings.Contains(connStr, "application_name="+applicationName()) {
+		t.Errorf("connStr = %q, %v; want application_name", connStr, err)
+	}
+}
+
+func TestAuthFailureExitsNonZero(t *testing.T) {
+	t.Cleanup(func() {
+		credentialsRejected = make(chan struct{})
+		credentialsRejectedOnce = sync.Once{}
+	})
+	for err, want := range map[error]bool{
+		&pq.Error{Code: "28P01"}:                         true,
+		fmt.Errorf("ping: %w", &pq.Error{Code: "28000"}): true,
+		&pq.Error{Code: "57P01"}:                         false,
+		&pq.Error{Code: "42P01"}:                         false,
+		driver.ErrBadConn:                                false,
+		nil:                                              false,
+	} {
+		if got := isAuthFailure(err); got != want {
+			t.Errorf("isAuthFailure(%v) = %t, want %t", err, got, want)
+		}
+	}
+
+	// Other failures keep us running until we are signalled
+	checkCredentials(driver.ErrBadConn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if code := waitForStop(ctx); code != 0 {
+		t.Errorf("signalled: exit code %d, want 0", code)
+	}
+
+	// A rejected password stops us at once, with a failing exit code
+	checkCredentials(&pq.Error{Code: "28P01"})
+	checkCredentials(&pq.Error{Code: "28P01"}) // only signalled once
+	if code := waitForStop(context.Background()); code != 1 {
+		t.Errorf("credentials rejected: exit code %d, want 1", code)
+	}
+}
+
+func TestConnectTimeoutInConnStr(t *testing.T) {
+	keyword := []string{"DATABASE_URL", "", "DB_HOST", "db", "POSTGRES_USER", "app", "POSTGRES_PASSWORD", "pw", "POSTGRES_DB", "app"}
+	for _, tc := range []struct {
+		name string
+		env  []string
+		want string
+	}{
+		{"keyword default", keyword, " connect_timeout=10"},
+		{"keyword", append(keyword, "DB_CONNECT_TIMEOUT", "3"), " connect_timeout=3"},
+		{"url", []string{"DATABASE_URL", "postgres://app@db/app", "DB_CONNECT_TIMEOUT", "3"}, "connect_timeout=3"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			connStr, err := buildConnStr(testConfig(t, tc.env...))
+			if err != nil || !strings.Contains(connStr, tc.want) {
+				t.Errorf("connStr = %q, %v; want %s", connStr, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestApplicationNameNamesTheReplica(t *testing.T) {
+	host, _ := os.Hostname()
+	name := applicationName()
+	if !strings.HasPrefix(name, serviceName+"/") || len(name) > 63 || !strings.HasPrefix(serviceName+"/"+host, name) {
+		t.Errorf("applicationName() = %q, want %s/<host name> within 63 bytes", name, serviceName)
+	}
+}
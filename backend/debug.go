@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Custom counters published at /debug/vars next to the standard memstats
+var (
+	requestsServed = expvar.NewInt("requests_served")
+	dbErrors       = expvar.NewInt("db_errors")
+)
+
+func init() {
+	expvar.Publish("db_reachable", expvar.Func(func() interface{} { return dbReachable.Load() }))
+}
+
+// registerDebugRoutes mounts the debug endpoints (only called when DEBUG is on)
+func registerDebugRoutes(rt *router, cfg Config) {
+	rt.Handle("GET /debug/vars", "expvar counters and memstats", expvar.Handler())
+
+	// 👇 The config report is opt-in and needs the admin token, even redacted
+	if cfg.DebugConfig {
+		if cfg.AdminToken == "" {
+			log.Println("🔒 ADMIN_TOKEN not set, /debug/config is disabled")
+			return
+		}
+		rt.HandleFunc("GET /debug/config", "Effective config, secrets redacted", requireAdmin(cfg.AdminToken, debugConfigHandler(cfg)))
+	}
+}
+
+// debugConfigHandler reports the config the process loaded, secrets redacted
+func debugConfigHandler(cfg Config) http.HandlerFunc {
+	report := configReport(cfg)
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+// logBodies is DEBUG: log create/update request bodies, redacted
+var logBodies bool
+
+// maxLoggedBody caps how much of a request body is captured for the log
+const maxLoggedBody = 4 << 10
+
+// maxLoggedString is how much of each string value is logged before it is
+// cut off
+const maxLoggedString = 64
+
+// sensitiveKeys are JSON keys whose values are never logged
+var sensitiveKeys = []string{"password", "secret", "token", "authorization", "api_key", "apikey"}
+
+// logRequestBodies logs the JSON body of each write request once the
+// handler has read it, with sensitive values redacted and long ones cut
+// short. The body is captured with a TeeReader, so the handler reads it as
+// usual. Only active in debug mode.
+func logRequestBodies(next http.Handler) http.Handler {
+	if !logBodies {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWrite(r) || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		captured := &cappedBuffer{limit: maxLoggedBody}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, captured), r.Body}
+		next.ServeHTTP(w, r)
+
+		log.Printf("🐛 %s %s body (request %s from %s): %s\n", r.Method, r.URL.Path, w.Header().Get(requestIDHeader), clientIP(r), redactBody(captured))
+	})
+}
+
+// cappedBuffer keeps the first limit bytes written to it and counts the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+	total int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.total += len(p)
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+// redactBody renders a captured body for the log
+func redactBody(b *cappedBuffer) string {
+	if b.total > b.limit {
+		return fmt.Sprintf("(%d bytes, too large to log)", b.total)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(b.Bytes(), &doc); err != nil {
+		return fmt.Sprintf("(%d bytes, not JSON)", b.total)
+	}
+	out, _ := json.Marshal(redactValue(doc))
+	return string(out)
+}
+
+// redactValue replaces sensitive values and truncates long strings
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if isSensitiveKey(key) {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redactValue(value)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+	case string:
+		if r := []rune(v); len(r) > maxLoggedString {
+			return fmt.Sprintf("%s…(%d chars)", string(r[:maxLoggedString]), len(r))
+		}
+	}
+	return v
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
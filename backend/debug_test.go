@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestDebugVars(t *testing.T) {
+	h := newTestHandler(testConfig(t, "DEBUG", "true"))
+	serve(h, "GET", "/stats", "") // counted in requests_served
+
+	rec := serve(h, "GET", "/debug/vars", "")
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &vars); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %.200s: %v", rec.Code, rec.Body, err)
+	}
+	for _, name := range []string{"requests_served", "db_errors", "cache_hits", "db_reachable", "memstats"} {
+		if _, ok := vars[name]; !ok {
+			t.Errorf("%s is missing", name)
+		}
+	}
+	var served int64
+	if json.Unmarshal(vars["requests_served"], &served); served < 2 {
+		t.Errorf("requests_served = %d, want at least 2", served)
+	}
+}
+
+func TestDebugVarsNeedDebug(t *testing.T) {
+	h := newTestHandler(testConfig(t))
+	if rec := serve(h, "GET", "/debug/vars", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 without DEBUG", rec.Code)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore is an in-memory UserStore for handler tests. Setting err makes
+// every call fail with it, e.g. to exercise the database error paths.
+type fakeStore struct {
+	mu     sync.Mutex
+	users  []User // by id
+	nextID int
+	now    time.Time
+	err    error
+}
+
+// useFakeStore installs a fakeStore holding a user per name as the global
+// store for the duration of the test
+func useFakeStore(t *testing.T, names ...string) *fakeStore {
+	t.Helper()
+	fs := &fakeStore{nextID: 1, now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	for _, name := range names {
+		fs.Create(context.Background(), name)
+	}
+	prev := store
+	store = fs
+	t.Cleanup(func() { store = prev })
+	return fs
+}
+
+// tick advances the fake clock, so every write gets a distinct timestamp
+func (s *fakeStore) tick() time.Time {
+	s.now = s.now.Add(time.Second)
+	return s.now
+}
+
+func (s *fakeStore) find(id int) int {
+	return slices.IndexFunc(s.users, func(u User) bool { return u.ID == id })
+}
+
+// sorted orders users like orderBy: by each field in turn, then by id
+func sorted(users []User, fields []SortField) []User {
+	users = slices.Clone(users)
+	slices.SortStableFunc(users, func(a, b User) int {
+		for _, f := range fields {
+			var c int
+			switch f.Column {
+			case "id":
+				c = cmp.Compare(a.ID, b.ID)
+			case "name":
+				c = strings.Compare(a.Name, b.Name)
+			case "created_at":
+				c = a.CreatedAt.Compare(b.CreatedAt)
+			case "updated_at":
+				c = a.UpdatedAt.Compare(b.UpdatedAt)
+			}
+			if f.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return users
+}
+
+// page applies limit (0 = all) and offset, never returning nil
+func page(users []User, limit, offset int) []User {
+	users = users[min(offset, len(users)):]
+	if limit > 0 && limit < len(users) {
+		users = users[:limit]
+	}
+	return append([]User{}, users...)
+}
+
+func (s *fakeStore) List(ctx context.Context, opts ListOptions) ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if _, err := orderBy(opts.Sort); err != nil {
+		return nil, err
+	}
+	return page(sorted(s.users, opts.Sort), opts.Limit, opts.Offset), nil
+}
+
+func (s *fakeStore) Count(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.users), s.err
+}
+
+func (s *fakeStore) CountSince(ctx context.Context, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, u := range s.users {
+		if !u.CreatedAt.Before(s.now.Add(-window)) {
+			n++
+		}
+	}
+	return n, s.err
+}
+
+func (s *fakeStore) Get(ctx context.Context, id int) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return User{}, s.err
+	}
+	if i := s.find(id); i >= 0 {
+		return s.users[i], nil
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *fakeStore) GetPrimary(ctx context.Context, id int) (User, error) {
+	return s.Get(ctx, id)
+}
+
+func (s *fakeStore) GetByName(ctx context.Context, name string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return User{}, s.err
+	}
+	var matches []User
+	for _, u := range s.users {
+		if strings.EqualFold(u.Name, name) {
+			matches = append(matches, u)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return User{}, ErrNotFound
+	case 1:
+		return matches[0], nil
+	}
+	return User{}, ErrAmbiguous
+}
+
+func (s *fakeStore) Create(ctx context.Context, name string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return User{}, s.err
+	}
+	now := s.tick()
+	u := User{ID: s.nextID, Name: name, CreatedAt: now, UpdatedAt: now}
+	s.nextID++
+	s.users = append(s.users, u)
+	return u, nil
+}
+
+func (s *fakeStore) Update(ctx context.Context, id int, name string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return User{}, s.err
+	}
+	i := s.find(id)
+	if i < 0 {
+		return User{}, ErrNotFound
+	}
+	s.users[i].Name, s.users[i].UpdatedAt = name, s.tick()
+	return s.users[i], nil
+}
+
+func (s *fakeStore) UpdateIfUnmodified(ctx context.Context, id int, name string, updatedAt time.Time) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return User{}, s.err
+	}
+	i := s.find(id)
+	if i < 0 || !s.users[i].UpdatedAt.Equal(updatedAt) {
+		return User{}, ErrModified
+	}
+	s.users[i].Name, s.users[i].UpdatedAt = name, s.tick()
+	return s.users[i], nil
+}
+
+func (s *fakeStore) Delete(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	i := s.find(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.users = slices.Delete(s.users, i, i+1)
+	return nil
+}
+
+func (s *fakeStore) RenameAll(ctx context.Context, ids []int, name string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	var n int64
+	now := s.tick()
+	for i := range s.users {
+		if slices.Contains(ids, s.users[i].ID) {
+			s.users[i].Name, s.users[i].UpdatedAt = name, now
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *fakeStore) UpsertByName(ctx context.Context, name string) (User, bool, error) {
+	u, err := s.GetByName(ctx, name)
+	switch {
+	case err == nil:
+		u, err = s.Update(ctx, u.ID, name)
+		return u, false, err
+	case err == ErrNotFound:
+		u, err = s.Create(ctx, name)
+		return u, err == nil, err
+	}
+	return User{}, false, err
+}
+
+func (s *fakeStore) SetCreatedAt(ctx context.Context, id int, createdAt time.Time) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return User{}, s.err
+	}
+	i := s.find(id)
+	if i < 0 {
+		return User{}, ErrNotFound
+	}
+	s.users[i].CreatedAt, s.users[i].UpdatedAt = createdAt, s.tick()
+	return s.users[i], nil
+}
+
+func (s *fakeStore) RenameMany(ctx context.Context, renames []Rename, partial bool) ([]RenameResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	results := make([]RenameResult, len(renames))
+	failed := false
+	for i, rn := range renames {
+		if s.find(rn.ID) < 0 {
+			results[i].Err = ErrNotFound
+			failed = true
+		}
+	}
+	if failed && !partial {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = errRolledBack
+			}
+		}
+		return results, nil
+	}
+	now := s.tick()
+	for i, rn := range renames {
+		if j := s.find(rn.ID); j >= 0 {
+			s.users[j].Name, s.users[j].UpdatedAt = rn.Name, now
+			results[i].User = s.users[j]
+		}
+	}
+	return results, nil
+}
+
+func (s *fakeStore) Search(ctx context.Context, query string, limit, offset int) ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	words := strings.Fields(strings.ToLower(query))
+	var matches []User
+	for _, u := range s.users {
+		name := strings.Fields(strings.ToLower(u.Name))
+		if !slices.ContainsFunc(words, func(w string) bool { return !slices.Contains(name, w) }) {
+			matches = append(matches, u)
+		}
+	}
+	return page(matches, limit, offset), nil
+}
+
+func (s *fakeStore) Filter(ctx context.Context, f UserFilter) ([]User, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, 0, s.err
+	}
+	var matches []User
+	for _, u := range s.users {
+		if f.NameContains != "" && !strings.Contains(strings.ToLower(u.Name), strings.ToLower(f.NameContains)) {
+			continue
+		}
+		if !f.CreatedAfter.IsZero() && u.CreatedAt.Before(f.CreatedAfter) {
+			continue
+		}
+		if !f.CreatedBefore.IsZero() && !u.CreatedAt.Before(f.CreatedBefore) {
+			continue
+		}
+		matches = append(matches, u)
+	}
+	return page(sorted(matches, f.Sort), f.Limit, f.Offset), len(matches), nil
+}
+
+func (s *fakeStore) Extremes(ctx context.Context) (oldest, newest *User, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil || len(s.users) == 0 {
+		return nil, nil, s.err
+	}
+	byAge := sorted(s.users, []SortField{{Column: "created_at"}})
+	return &byAge[0], &byAge[len(byAge)-1], nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// flagNamePattern keeps flag names simple: lowercase words joined by _
+var flagNamePattern = regexp.MustCompile(`^[a-z0-9_]{1,100}$`)
+
+// FlagStore holds feature flags. Defaults come from FEATURE_FLAGS at
+// startup; overrides set via PUT /admin/flags are persisted in the
+// feature_flags table so they survive restarts and reach every replica.
+type FlagStore struct {
+	mu        sync.RWMutex
+	defaults  map[string]bool
+	overrides map[string]bool
+}
+
+// defaultFlags are on until FEATURE_FLAGS or an override turns them off.
+// fts_search serves GET /api/users/search.
+var defaultFlags = map[string]bool{"fts_search": true}
+
+// flags is checked at request time, e.g. if flags.Enabled("fts_search") {...}
+var flags = &FlagStore{defaults: maps.Clone(defaultFlags), overrides: map[string]bool{}}
+
+// Enabled reports whether the named flag is on; unknown flags are off
+func (f *FlagStore) Enabled(name string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if v, ok := f.overrides[name]; ok {
+		return v
+	}
+	return f.defaults[name]
+}
+
+// All returns the effective value of every known flag
+func (f *FlagStore) All() map[string]bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	all := make(map[string]bool, len(f.defaults)+len(f.overrides))
+	for name, v := range f.defaults {
+		all[name] = v
+	}
+	for name, v := range f.overrides {
+		all[name] = v
+	}
+	return all
+}
+
+// SetDefaults replaces the startup defaults
+func (f *FlagStore) SetDefaults(defaults map[string]bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.defaults = defaults
+}
+
+// Load replaces the overrides with what is stored in the database
+func (f *FlagStore) Load(ctx context.Context) error {
+	rows, err := db.QueryContext(ctx, "SELECT name, enabled FROM feature_flags")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	overrides := map[string]bool{}
+	for rows.Next() {
+		var name string
+		var enabled bool
+		if err := rows.Scan(&name, &enabled); err != nil {
+			return err
+		}
+		overrides[name] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.overrides = overrides
+	f.mu.Unlock()
+	return nil
+}
+
+// Set persists overrides in one transaction, so either all of them apply
+// or none do, and applies them immediately
+func (f *FlagStore) Set(ctx context.Context, overrides map[string]bool) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for name, enabled := range overrides {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO feature_flags (name, enabled) VALUES ($1, $2)
+			ON CONFLICT (name) DO UPDATE SET enabled = EXCLUDED.enabled, updated_at = CURRENT_TIMESTAMP`,
+			name, enabled)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	for name, enabled := range overrides {
+		f.overrides[name] = enabled
+	}
+	f.mu.Unlock()
+	return nil
+}
+
+// refreshFlags reloads overrides so changes made on another replica apply here too
+func refreshFlags(ctx context.Context) {
+	if err := flags.Load(ctx); err != nil {
+		log.Println("⚠️ Failed to reload feature flags:", err)
+	}
+}
+
+// parseFlags reads FEATURE_FLAGS, e.g. "fts_search=true,new_ui" (a bare
+// name means true)
+func parseFlags(v string) (map[string]bool, error) {
+	parsed := maps.Clone(defaultFlags)
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(entry, "=")
+		if !flagNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid FEATURE_FLAGS name %q", name)
+		}
+		enabled := true
+		if hasValue {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid FEATURE_FLAGS value for %s: %q", name, value)
+			}
+			enabled = b
+		}
+		parsed[name] = enabled
+	}
+	return parsed, nil
+}
+
+// flagsHandler lists the effective value of every flag
+func flagsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, flags.All())
+}
+
+// setFlagsHandler overrides flags from a {"name": bool, ...} body
+func setFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	var req map[string]bool
+	if err := decodeJSON(r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if len(req) == 0 {
+		writeError(w, http.StatusBadRequest, `body must be {"flag_name": true|false, ...}`)
+		return
+	}
+	for name := range req {
+		if !flagNamePattern.MatchString(name) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid flag name %q", name))
+			return
+		}
+	}
+
+	if err := flags.Set(r.Context(), req); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	for name, enabled := range req {
+		log.Printf("🚩 Feature flag %s set to %t\n", name, enabled)
+	}
+	writeJSON(w, http.StatusOK, flags.All())
+}
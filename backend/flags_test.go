@@ -0,0 +1,94 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"testing"
+)
+
+// useFlags replaces the global flags with defaults and no overrides for the
+// duration of the test
+func useFlags(t *testing.T, defaults map[string]bool) *FlagStore {
+	t.Helper()
+	prev := flags
+	flags = &FlagStore{defaults: defaults, overrides: map[string]bool{}}
+	t.Cleanup(func() { flags = prev })
+	return flags
+}
+
+func TestParseFlags(t *testing.T) {
+	for v, want := range map[string]map[string]bool{
+		"":                          {"fts_search": true},
+		"new_ui":                    {"fts_search": true, "new_ui": true},
+		"fts_search=false, beta=1 ": {"fts_search": false, "beta": true},
+	} {
+		got, err := parseFlags(v)
+		if err != nil || !maps.Equal(got, want) {
+			t.Errorf("parseFlags(%q) = %v, %v; want %v", v, got, err, want)
+		}
+	}
+	for _, v := range []string{"New-UI", "beta=maybe", "=true"} {
+		if _, err := parseFlags(v); err == nil {
+			t.Errorf("parseFlags(%q): no error", v)
+		}
+	}
+}
+
+func TestFlagOverridesWin(t *testing.T) {
+	f := useFlags(t, map[string]bool{"a": true, "b": false})
+	f.overrides["a"] = false
+	if f.Enabled("a") || f.Enabled("b") || f.Enabled("unknown") {
+		t.Errorf("Enabled: a=%t b=%t unknown=%t, want all false", f.Enabled("a"), f.Enabled("b"), f.Enabled("unknown"))
+	}
+	if all := f.All(); !maps.Equal(all, map[string]bool{"a": false, "b": false}) {
+		t.Errorf("All() = %v", all)
+	}
+}
+
+func TestSearchNeedsFTSFlag(t *testing.T) {
+	useFakeStore(t, "Ada Lovelace", "Alan Turing")
+	h := newTestHandler(testConfig(t))
+
+	f := useFlags(t, map[string]bool{"fts_search": true})
+	rec := serve(h, "GET", "/api/users/search?q=alan", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("flag on: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	f.overrides["fts_search"] = false
+	if rec := serve(h, "GET", "/api/users/search?q=alan", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("flag off: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestSetFlagsRejectsBadBodies(t *testing.T) {
+	useFlags(t, map[string]bool{})
+	h := newTestHandler(testConfig(t, "ADMIN_TOKEN", "s3cret"))
+
+	for _, body := range []string{`{}`, `{"Bad Name": true}`, `{"beta": "yes"}`, `["beta"]`, `{"beta": true} {}`} {
+		rec := serve(h, "PUT", "/admin/flags", body, "Authorization", "Bearer s3cret")
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s: status = %d, want 400", body, rec.Code)
+		}
+	}
+	rec := serve(h, "PUT", "/admin/flags", "beta=true", "Authorization", "Bearer s3cret", "Content-Type", "text/plain")
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain: status = %d, want 415", rec.Code)
+	}
+	if len(flags.All()) != 0 {
+		t.Errorf("flags = %v, want none set", flags.All())
+	}
+}
+
+func TestFeatureFlagsFromEnv(t *testing.T) {
+	cfg := testConfig(t, "FEATURE_FLAGS", "new_ui,fts_search=false")
+	f := useFlags(t, nil)
+	f.SetDefaults(cfg.FeatureFlags)
+	if !f.Enabled("new_ui") || f.Enabled("fts_search") {
+		t.Errorf("flags = %v, want new_ui on and fts_search off", f.All())
+	}
+
+	if err := configError(t, "FEATURE_FLAGS", "new ui"); err == nil {
+		t.Error("FEATURE_FLAGS=new ui: no error")
+	}
+}
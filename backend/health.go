@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// dependencyCheck probes one thing the backend depends on
+type dependencyCheck struct {
+	Name string
+	// Critical dependencies make us unready when down; non-critical ones
+	// only mark us as degraded
+	Critical bool
+	Timeout  time.Duration
+	Check    func(ctx context.Context) error
+}
+
+// readinessChecks are run by the readiness probe; they are registered in main
+var readinessChecks []dependencyCheck
+
+// runChecks runs every check concurrently, each bounded by its own timeout,
+// and reports "ok", "down" or "migrations pending" per dependency plus whether we are still ready
+func runChecks(ctx context.Context, checks []dependencyCheck) (statuses map[string]string, ready, degraded bool) {
+	statuses = make(map[string]string, len(checks))
+	ready = true
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c dependencyCheck) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+			defer cancel()
+			err := c.Check(checkCtx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				statuses[c.Name] = "ok"
+				return
+			}
+			log.Printf("⚠️ Readiness check %q failed: %v\n", c.Name, err)
+			statuses[c.Name] = "down"
+			if errors.Is(err, errMigrationsPending) {
+				statuses[c.Name] = "migrations pending"
+			}
+			if c.Critical {
+				ready = false
+			} else {
+				degraded = true
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return statuses, ready, degraded
+}
+
+// cacheCheck reuses a passing result of check for ttl, so rapid probes
+// share one call. Failures are never cached: once check starts failing,
+// every probe sees it within ttl, and recovery is noticed at once.
+func cacheCheck(check func(ctx context.Context) error, ttl time.Duration) func(ctx context.Context) error {
+	if ttl <= 0 {
+		return check
+	}
+
+	var mu sync.Mutex
+	var passedAt time.Time
+	return func(ctx context.Context) error {
+		// 👇 Held across the check, so concurrent probes wait for one
+		// call instead of each making their own
+		mu.Lock()
+		defer mu.Unlock()
+
+		if time.Since(passedAt) < ttl {
+			return nil
+		}
+		if err := check(ctx); err != nil {
+			return err
+		}
+		passedAt = time.Now()
+		return nil
+	}
+}
+
+// checkTempDir writes and removes a small file in the temp directory, so a
+// read-only or full filesystem shows up in the readiness probe
+func checkTempDir(ctx context.Context) error {
+	f, err := os.CreateTemp("", "readyz-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString("ok"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readyFailureThreshold is how many probes in a row must find a critical
+// dependency down before we report unready (READY_FAILURE_THRESHOLD)
+var readyFailureThreshold int64 = 1
+
+// readyFailures counts consecutive probes with a critical dependency down
+var readyFailures atomic.Int64
+
+// shuttingDown is set on SIGTERM so the readiness probe fails at once
+var shuttingDown atomic.Bool
+
+// readyzHandler reports whether we can serve traffic, with per-dependency
+// detail. A critical failure only makes us unready once it has been seen by
+// readyFailureThreshold probes in a row; until then we report degraded, so a
+// brief database blip doesn't pull the pod out of the Service.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if shuttingDown.Load() {
+		w.Header().Set("Retry-After", dbRetryAfter)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "shutting_down"})
+		return
+	}
+
+	statuses, ready, degraded := runChecks(r.Context(), readinessChecks)
+	if ready {
+		readyFailures.Store(0)
+	} else if readyFailures.Add(1) < readyFailureThreshold {
+		ready, degraded = true, true
+	}
+
+	status := "ready"
+	code := http.StatusOK
+	switch {
+	case !ready:
+		status = "unavailable"
+		code = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", dbRetryAfter)
+	case degraded:
+		status = "degraded"
+	}
+
+	writeJSON(w, code, map[string]interface{}{
+		"status": status,
+		"checks": statuses,
+	})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useChecks makes checks the readiness probe's dependencies for the test
+func useChecks(t *testing.T, checks ...dependencyCheck) {
+	t.Helper()
+	prev := readinessChecks
+	readinessChecks = checks
+	readyFailures.Store(0)
+	t.Cleanup(func() {
+		readinessChecks = prev
+		readyFailures.Store(0)
+	})
+}
+
+func up(ctx context.Context) error   { return nil }
+func down(ctx context.Context) error { return errors.New("connection refused") }
+
+// readiness probes cfg's readiness endpoint and decodes the answer
+func readiness(t *testing.T, h http.Handler, cfg Config) (int, string, map[string]string) {
+	t.Helper()
+	rec := serve(h, "GET", cfg.ReadyPath, "")
+	var resp struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+	return rec.Code, resp.Status, resp.Checks
+}
+
+func TestReadyWithNonCriticalDependencyDown(t *testing.T) {
+	useChecks(t,
+		dependencyCheck{Name: "database", Critical: true, Timeout: time.Second, Check: up},
+		dependencyCheck{Name: "redis", Timeout: time.Second, Check: down},
+	)
+	cfg := testConfig(t)
+
+	code, status, checks := readiness(t, newTestHandler(cfg), cfg)
+	if code != http.StatusOK || status != "degraded" {
+		t.Errorf("got %d %q, want 200 degraded", code, status)
+	}
+	if checks["database"] != "ok" || checks["redis"] != "down" {
+		t.Errorf("checks = %v", checks)
+	}
+}
+
+func TestUnreadyWithCriticalDependencyDown(t *testing.T) {
+	useChecks(t,
+		dependencyCheck{Name: "database", Critical: true, Timeout: time.Second, Check: down},
+		dependencyCheck{Name: "redis", Timeout: time.Second, Check: up},
+	)
+	cfg := testConfig(t)
+
+	code, status, checks := readiness(t, newTestHandler(cfg), cfg)
+	if code != http.StatusServiceUnavailable || status != "unavailable" || checks["database"] != "down" {
+		t.Errorf("got %d %q %v, want 503 unavailable", code, status, checks)
+	}
+}
+
+func TestReadyFailureThreshold(t *testing.T) {
+	useChecks(t, dependencyCheck{Name: "database", Critical: true, Timeout: time.Second, Check: down})
+	readyFailureThreshold = 3
+	t.Cleanup(func() { readyFailureThreshold = 1 })
+	cfg := testConfig(t)
+	h := newTestHandler(cfg)
+
+	for i := 1; i < 3; i++ {
+		if code, status, _ := readiness(t, h, cfg); code != http.StatusOK || status != "degraded" {
+			t.Errorf("probe %d: got %d %q, want 200 degraded", i, code, status)
+		}
+	}
+	if code, _, _ := readiness(t, h, cfg); code != http.StatusServiceUnavailable {
+		t.Errorf("probe 3: status = %d, want 503", code)
+	}
+}
+
+func TestReadyFailuresMustBeConsecutive(t *testing.T) {
+	var healthy atomic.Bool
+	useChecks(t, dependencyCheck{Name: "database", Critical: true, Timeout: time.Second, Check: func(ctx context.Context) error {
+		if healthy.Load() {
+			return nil
+		}
+		return errors.New("connection refused")
+	}})
+	readyFailureThreshold = 3
+	t.Cleanup(func() { readyFailureThreshold = 1 })
+	cfg := testConfig(t)
+	h := newTestHandler(cfg)
+
+	// A flapping dependency never fails three probes in a row
+	for i, ok := range []bool{false, false, true, false, false, true, false} {
+		healthy.Store(ok)
+		if code, _, _ := readiness(t, h, cfg); code != http.StatusOK {
+			t.Fatalf("probe %d: status = %d, want 200", i+1, code)
+		}
+	}
+	readiness(t, h, cfg)
+	if code, status, _ := readiness(t, h, cfg); code != http.StatusServiceUnavailable || status != "unavailable" {
+		t.Errorf("third failure in a row: got %d %q, want 503 unavailable", code, status)
+	}
+}
+
+func TestUnreadyAtOnceWhenShuttingDown(t *testing.T) {
+	useChecks(t, dependencyCheck{Name: "database", Critical: true, Timeout: time.Second, Check: up})
+	readyFailureThreshold = 3
+	t.Cleanup(func() { readyFailureThreshold = 1 })
+	cfg := testConfig(t)
+	h := newTestHandler(cfg)
+
+	if code, _, _ := readiness(t, h, cfg); code != http.StatusOK {
+		t.Fatalf("before shutdown: status = %d, want 200", code)
+	}
+	shuttingDown.Store(true)
+	t.Cleanup(func() { shuttingDown.Store(false) })
+	if code, status, _ := readiness(t, h, cfg); code != http.StatusServiceUnavailable || status != "shutting_down" {
+		t.Errorf("first probe after shutdown: got %d %q, want 503 shutting_down", code, status)
+	}
+}
+
+func TestReadyFailureThresholdConfig(t *testing.T) {
+	if cfg := testConfig(t); cfg.ReadyFailureThreshold != 1 {
+		t.Errorf("default = %d, want 1", cfg.ReadyFailureThreshold)
+	}
+	if cfg := testConfig(t, "READY_FAILURE_THRESHOLD", "5"); cfg.ReadyFailureThreshold != 5 {
+		t.Errorf("READY_FAILURE_THRESHOLD=5 gave %d", cfg.ReadyFailureThreshold)
+	}
+	if err := configError(t, "READY_FAILURE_THRESHOLD", "often"); err == nil {
+		t.Error("READY_FAILURE_THRESHOLD=often was accepted")
+	}
+}
+
+func TestChecksRunConcurrentlyWithTimeouts(t *testing.T) {
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	checks := []dependencyCheck{
+		{Name: "a", Timeout: 50 * time.Millisecond, Check: hang},
+		{Name: "b", Timeout: 50 * time.Millisecond, Check: hang},
+		{Name: "c", Timeout: 50 * time.Millisecond, Check: hang},
+	}
+
+	start := time.Now()
+	statuses, ready, degraded := runChecks(context.Background(), checks)
+	if took := time.Since(start); took > 140*time.Millisecond {
+		t.Errorf("took %s, want the checks to time out together", took)
+	}
+	if !ready || !degraded || statuses["a"] != "down" || statuses["c"] != "down" {
+		t.Errorf("got %v ready=%t degraded=%t", statuses, ready, degraded)
+	}
+}
+
+func TestCacheCheckOnlyCachesPasses(t *testing.T) {
+	calls := 0
+	var err error
+	check := cacheCheck(func(ctx context.Context) error {
+		calls++
+		return err
+	}, time.Minute)
+
+	err = errors.New("down")
+	check(context.Background())
+	check(context.Background())
+	if calls != 2 {
+		t.Errorf("failures: %d calls, want 2 (never cached)", calls)
+	}
+
+	err = nil
+	check(context.Background())
+	check(context.Background())
+	if calls != 3 {
+		t.Errorf("passes: %d calls, want 3 (cached after the first)", calls)
+	}
+}
+
+func TestRapidProbesShareOnePing(t *testing.T) {
+	var pings atomic.Int32
+	var dbDown atomic.Bool
+	ping := func(ctx context.Context) error {
+		pings.Add(1)
+		if dbDown.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	useChecks(t, dependencyCheck{Name: "database", Critical: true, Timeout: time.Second, Check: cacheCheck(ping, 50*time.Millisecond)})
+	cfg := testConfig(t)
+	h := newTestHandler(cfg)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve(h, "GET", cfg.ReadyPath, "")
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 5; i++ {
+		if code, _, _ := readiness(t, h, cfg); code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", code)
+		}
+	}
+	if n := pings.Load(); n != 1 {
+		t.Errorf("%d pings for 15 probes within the window, want 1", n)
+	}
+
+	// An outage shows up as soon as the cached pass expires
+	dbDown.Store(true)
+	time.Sleep(60 * time.Millisecond)
+	if code, _, checks := readiness(t, h, cfg); code != http.StatusServiceUnavailable || checks["database"] != "down" {
+		t.Errorf("after the window: got %d %v, want 503 with the database down", code, checks)
+	}
+}
+
+func TestReadyCheckCacheTTLConfig(t *testing.T) {
+	if cfg := testConfig(t); cfg.ReadyCheckCacheTTL != time.Second {
+		t.Errorf("default = %s, want 1s", cfg.ReadyCheckCacheTTL)
+	}
+	if cfg := testConfig(t, "READY_CHECK_CACHE_TTL", "0"); cfg.ReadyCheckCacheTTL != 0 {
+		t.Errorf("READY_CHECK_CACHE_TTL=0 gave %s", cfg.ReadyCheckCacheTTL)
+	}
+
+	calls := 0
+	check := cacheCheck(func(ctx context.Context) error { calls++; return nil }, 0)
+	check(context.Background())
+	check(context.Background())
+	if calls != 2 {
+		t.Errorf("ttl 0: %d calls, want every probe to check", calls)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is used when Accept-Language names nothing we support
+const defaultLanguage = "en"
+
+// messages translates client-facing error messages, keyed by language and
+// then by the English message. Anything missing is sent in English.
+var messages = map[string]map[string]string{
+	"es": {
+		"unauthorized":                           "no autorizado",
+		"maintenance in progress":                "mantenimiento en curso",
+		"server is read-only":                    "el servidor es de solo lectura",
+		"database temporarily unavailable":       "base de datos temporalmente no disponible",
+		"database ping failed":                   "falló el ping a la base de datos",
+		"internal server error":                  "error interno del servidor",
+		"database busy: no connection available": "base de datos ocupada: no hay conexiones disponibles",
+		"database query timed out":               "la consulta a la base de datos excedió el tiempo límite",
+		"user not found":                         "usuario no encontrado",
+		"multiple users match":                   "varios usuarios coinciden",
+		"user already exists":                    "el usuario ya existe",
+		"user was modified":                      "el usuario fue modificado",
+		"invalid user data":                      "datos de usuario no válidos",
+		"name is required":                       "el nombre es obligatorio",
+		"q is required":                          "q es obligatorio",
+		"invalid user id":                        "id de usuario no válido",
+		"Content-Type must be application/json":  "Content-Type debe ser application/json",
+		"request body is too large":              "el cuerpo de la solicitud es demasiado grande",
+		"invalid JSON body":                      "cuerpo JSON no válido",
+		"request body does not match schema":     "el cuerpo de la solicitud no coincide con el esquema",
+		"limit must be a positive integer":       "limit debe ser un entero positivo",
+		"offset must be a non-negative integer":  "offset debe ser un entero no negativo",
+		"partial must be true or false":          "partial debe ser true o false",
+		"not available in production":            "no disponible en producción",
+
+		fmt.Sprintf("name must be at most %d characters", maxNameLength):   fmt.Sprintf("el nombre debe tener como máximo %d caracteres", maxNameLength),
+		fmt.Sprintf("ids must contain 1 to %d items", maxBulkIDs):          fmt.Sprintf("ids debe contener entre 1 y %d elementos", maxBulkIDs),
+		fmt.Sprintf("updates must contain 1 to %d items", maxBatchRenames): fmt.Sprintf("updates debe contener entre 1 y %d elementos", maxBatchRenames),
+	},
+}
+
+// negotiateLanguage picks the supported language the client prefers most
+// from an Accept-Language header like "es-MX,es;q=0.9,en;q=0.5"
+func negotiateLanguage(header string) string {
+	best, bestQ := defaultLanguage, 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if lang != defaultLanguage && messages[lang] == nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// negotiateErrorLanguage records the client's language as the response's
+// Content-Language, which writeError then translates into. It must wrap the
+// handlers inside limitDuration, whose writer has its own header map.
+func negotiateErrorLanguage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Language", negotiateLanguage(r.Header.Get("Accept-Language")))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// localize translates message into the response's Content-Language
+func localize(w http.ResponseWriter, message string) string {
+	if translated, ok := messages[w.Header().Get("Content-Language")][message]; ok {
+		return translated
+	}
+	return message
+}
@@ -0,0 +1,560 @@
+//go:build integration
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// Integration tests run the handlers against a real Postgres started in a
+// container: go test -tags integration ./... (needs Docker). With -short
+// they are skipped and no container is started.
+
+// integrationURL is the DATABASE_URL of the test container
+var integrationURL string
+
+func TestMain(m *testing.M) {
+	// 👇 runMain re-executes the test binary as the real server
+	if os.Getenv("BACKEND_RUN_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+
+	flag.Parse()
+	if testing.Short() {
+		os.Exit(m.Run())
+	}
+
+	ctx := context.Background()
+	ctr, err := postgres.Run(ctx, "postgres:15-alpine",
+		postgres.WithDatabase("app"),
+		postgres.WithUsername("app"),
+		postgres.WithPassword("app"),
+		testcontainers.WithWaitStrategy(wait.ForLog("database system is ready to accept connections").
+			WithOccurrence(2).WithStartupTimeout(time.Minute)),
+	)
+	if err != nil {
+		log.Fatal("Failed to start Postgres container:", err)
+	}
+	if integrationURL, err = ctr.ConnectionString(ctx, "sslmode=disable"); err != nil {
+		log.Fatal("Failed to get the container's connection string:", err)
+	}
+
+	code := m.Run()
+	if err := ctr.Terminate(ctx); err != nil {
+		log.Println("⚠️ Failed to stop Postgres container:", err)
+	}
+	os.Exit(code)
+}
+
+// useDatabase points db and store at the test container, initialized like
+// main does (migrations, and seeding if env asks for it), with env (key,
+// value pairs) applied to the config. The users table is emptied first, so
+// it only holds what seeding put there.
+func useDatabase(t *testing.T, env ...string) Config {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("integration test: needs Docker")
+	}
+	cfg := testConfig(t, append([]string{"DATABASE_URL", integrationURL}, env...)...)
+
+	pool, err := openDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevDB, prevStore := db, store
+	t.Cleanup(func() {
+		pool.Close()
+		db, store = prevDB, prevStore
+	})
+	db = pool
+
+	// 👇 Tests share the container, so clear what earlier ones left behind
+	// before initDatabase (the table may not exist yet on the first run)
+	db.Exec("TRUNCATE users RESTART IDENTITY")
+	initDatabase(cfg)
+	store = &postgresStore{
+		db:             db,
+		retryReads:     cfg.DBRetryReads,
+		tagQueries:     cfg.DBQueryTags,
+		acquireTimeout: cfg.DBAcquireTimeout,
+		queryTimeout:   cfg.DBQueryTimeout,
+	}
+	return cfg
+}
+
+func TestIntegrationUserCRUD(t *testing.T) {
+	cfg := useDatabase(t)
+	h := newTestHandler(cfg)
+
+	// Create
+	for _, name := range []string{"Ada Lovelace", "Grace Hopper", "Alan Turing"} {
+		rec := serve(h, "POST", "/api/users", fmt.Sprintf(`{"name": %q}`, name))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create %s: status = %d: %s", name, rec.Code, rec.Body)
+		}
+	}
+
+	// List, sorted and paged
+	rec := serve(h, "GET", "/api/users?sort=-name&limit=2", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: status = %d: %s", rec.Code, rec.Body)
+	}
+	var users []User
+	json.Unmarshal(rec.Body.Bytes(), &users)
+	if len(users) != 2 || users[0].Name != "Grace Hopper" || users[1].Name != "Alan Turing" {
+		t.Errorf("list = %+v, want Grace Hopper then Alan Turing", users)
+	}
+	if got := rec.Header().Get("X-Total-Count"); got != "3" {
+		t.Errorf("X-Total-Count = %q, want 3", got)
+	}
+
+	// List with filters
+	rec = serve(h, "POST", "/api/users/search", `{"name_contains": "a", "sort": "name", "limit": 10}`)
+	var found struct {
+		Users []User `json:"users"`
+		Total int    `json:"total"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &found)
+	if rec.Code != http.StatusOK || found.Total != 3 || found.Users[0].Name != "Ada Lovelace" {
+		t.Errorf("filter: status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	// Get
+	rec = serve(h, "GET", "/api/users/2", "")
+	if u := decodeUser(t, rec.Body.String()); rec.Code != http.StatusOK || u.Name != "Grace Hopper" {
+		t.Errorf("get: status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	// Update
+	rec = serve(h, "PUT", "/api/users/2", `{"name": "Rear Admiral Hopper"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update: status = %d: %s", rec.Code, rec.Body)
+	}
+	if u, err := store.Get(context.Background(), 2); err != nil || u.Name != "Rear Admiral Hopper" {
+		t.Errorf("after update: %+v, %v", u, err)
+	}
+
+	// Delete
+	if rec := serve(h, "DELETE", "/api/users/2", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "GET", "/api/users/2", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("get after delete: status = %d, want 404", rec.Code)
+	}
+	if n, _ := store.Count(context.Background()); n != 2 {
+		t.Errorf("count = %d, want 2", n)
+	}
+}
+
+func TestIntegrationSchema(t *testing.T) {
+	cfg := useDatabase(t, "SCHEMA_REQUIRE_ADMIN", "true", "ADMIN_TOKEN", "s3cret")
+	h := newTestHandler(cfg)
+
+	if rec := serve(h, "GET", "/api/schema", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status = %d, want 401", rec.Code)
+	}
+	rec := serve(h, "GET", "/api/schema", "", "Authorization", "Bearer s3cret")
+	var resp struct {
+		Columns []Column `json:"columns"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	var names []string
+	for _, c := range resp.Columns {
+		names = append(names, c.Name)
+	}
+	if rec.Code != http.StatusOK || fmt.Sprint(names) != "[id name created_at updated_at]" {
+		t.Errorf("status = %d, columns = %v", rec.Code, names)
+	}
+}
+
+func TestIntegrationSetFlags(t *testing.T) {
+	cfg := useDatabase(t, "ADMIN_TOKEN", "s3cret")
+	useFlags(t, map[string]bool{})
+	db.Exec("DELETE FROM feature_flags")
+	h := newTestHandler(cfg)
+
+	rec := serve(h, "PUT", "/admin/flags", `{"fts_search": false, "new_ui": true}`, "Authorization", "Bearer s3cret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "GET", "/api/users/search?q=ada", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("search with fts_search off: status = %d, want 404", rec.Code)
+	}
+
+	// Another replica picks both overrides up from the table
+	other := &FlagStore{defaults: map[string]bool{}, overrides: map[string]bool{}}
+	if err := other.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if other.Enabled("fts_search") || !other.Enabled("new_ui") {
+		t.Errorf("loaded flags = %v", other.All())
+	}
+}
+
+func TestIntegrationUpdateIfMatch(t *testing.T) {
+	cfg := useDatabase(t)
+	h := newTestHandler(cfg)
+	serve(h, "POST", "/api/users", `{"name": "Ada"}`)
+
+	etag := serve(h, "GET", "/api/users/1", "").Header().Get("ETag")
+	if rec := serve(h, "PUT", "/api/users/1", `{"name": "Ada Lovelace"}`, "If-Match", etag); rec.Code != http.StatusOK {
+		t.Fatalf("current ETag: status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "PUT", "/api/users/1", `{"name": "Ada King"}`, "If-Match", etag); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale ETag: status = %d, want 412", rec.Code)
+	}
+	serve(h, "DELETE", "/api/users/1", "")
+	if rec := serve(h, "PUT", "/api/users/1", `{"name": "Ada King"}`, "If-Match", etag); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("deleted user: status = %d, want 412", rec.Code)
+	}
+}
+
+func TestIntegrationStatementTimeout(t *testing.T) {
+	useDatabase(t, "DB_STATEMENT_TIMEOUT", "100ms")
+
+	// 👇 No Go deadline here, so only Postgres can cut the query short
+	start := time.Now()
+	_, err := db.ExecContext(context.Background(), "SELECT pg_sleep(5)")
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "57014" {
+		t.Fatalf("err = %v, want query_canceled (57014)", err)
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("query ran for %s, want it cancelled after about 100ms", took)
+	}
+}
+
+func TestIntegrationCustomSchema(t *testing.T) {
+	useDatabase(t) // empties public.users, for comparison
+	cfg := useDatabase(t, "DB_SCHEMA", "tenant_a")
+	h := newTestHandler(cfg)
+
+	if rec := serve(h, "POST", "/api/users", `{"name": "Ada"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "GET", "/api/users/1", ""); rec.Code != http.StatusOK {
+		t.Errorf("get: status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var inSchema, inPublic int
+	db.QueryRow("SELECT COUNT(*) FROM tenant_a.users").Scan(&inSchema)
+	db.QueryRow("SELECT COUNT(*) FROM public.users").Scan(&inPublic)
+	if inSchema != 1 || inPublic != 0 {
+		t.Errorf("tenant_a.users has %d rows and public.users %d, want 1 and 0", inSchema, inPublic)
+	}
+	var migrations int
+	db.QueryRow("SELECT COUNT(*) FROM tenant_a.schema_migrations").Scan(&migrations)
+	if all, _ := loadMigrations(); migrations != len(all) {
+		t.Errorf("tenant_a.schema_migrations has %d rows, want every migration", migrations)
+	}
+}
+
+func TestIntegrationStartupWithoutSeeding(t *testing.T) {
+	useDatabase(t)
+	if n, err := store.Count(context.Background()); err != nil || n != 0 {
+		t.Errorf("count = %d, %v; want no users without SEED_DATA", n, err)
+	}
+}
+
+func TestIntegrationSeedingIsIdempotent(t *testing.T) {
+	cfg := useDatabase(t, "SEED_DATA", "true")
+	if n, _ := store.Count(context.Background()); n != len(seedUsers) {
+		t.Fatalf("count = %d, want the %d seed users", n, len(seedUsers))
+	}
+
+	initDatabase(cfg) // a restart
+	if n, _ := store.Count(context.Background()); n != len(seedUsers) {
+		t.Errorf("count after restart = %d, want still %d", n, len(seedUsers))
+	}
+}
+
+func TestIntegrationAdminSchema(t *testing.T) {
+	cfg := useDatabase(t, "ADMIN_TOKEN", "s3cret")
+	h := newTestHandler(cfg)
+
+	rec := serve(h, "GET", "/admin/schema", "", "Authorization", "Bearer s3cret")
+	var resp struct {
+		MigrationVersion int      `json:"migration_version"`
+		Columns          []Column `json:"columns"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+
+	var applied int
+	db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&applied)
+	latest, _ := latestMigration()
+	if rec.Code != http.StatusOK || resp.MigrationVersion != applied || applied != latest {
+		t.Errorf("status = %d, migration_version = %d; applied %d, latest embedded %d", rec.Code, resp.MigrationVersion, applied, latest)
+	}
+	if len(resp.Columns) != 4 {
+		t.Errorf("columns = %+v, want the 4 users columns", resp.Columns)
+	}
+}
+
+func TestIntegrationCredentialProvider(t *testing.T) {
+	useDatabase(t)
+	u, _ := url.Parse(integrationURL)
+	password, _ := u.User.Password()
+	u.User = url.User(u.User.Username()) // the DSN itself has no password
+
+	provider := &countingProvider{password: password}
+	pool := sql.OpenDB(&credentialConnector{dsn: u.String(), provider: provider})
+	defer pool.Close()
+	pool.SetMaxIdleConns(0) // every query opens a new connection
+
+	for i := 0; i < 2; i++ {
+		if err := pool.Ping(); err != nil {
+			t.Fatalf("ping %d: %v", i, err)
+		}
+	}
+	if provider.calls != 2 {
+		t.Errorf("provider called %d times, want once per connection", provider.calls)
+	}
+}
+
+// countingProvider returns password and counts how often it was asked
+type countingProvider struct {
+	password string
+	calls    int
+}
+
+func (p *countingProvider) Password(ctx context.Context) (string, error) {
+	p.calls++
+	return p.password, nil
+}
+
+func TestIntegrationUsersTotal(t *testing.T) {
+	cfg := useDatabase(t)
+	h := newTestHandler(cfg)
+	for _, name := range []string{"Ada", "Grace", "Alan"} {
+		serve(h, "POST", "/api/users", fmt.Sprintf(`{"name": %q}`, name))
+	}
+
+	refreshUsersTotal(context.Background())
+	if got := gaugeValue(usersTotal); got != 3 {
+		t.Errorf("users_total = %v, want 3", got)
+	}
+}
+
+func TestIntegrationRetryReadAfterDroppedConnection(t *testing.T) {
+	cfg := useDatabase(t, "DB_RETRY_READS", "true")
+	h := newTestHandler(cfg)
+	serve(h, "POST", "/api/users", `{"name": "Ada"}`)
+
+	// 👇 A failover as the pool sees it: its idle connections are killed
+	// server-side, so the next query on one of them fails
+	db.SetMaxIdleConns(1)
+	var pid int
+	db.QueryRow("SELECT pg_backend_pid()").Scan(&pid)
+	admin, err := sql.Open("postgres", integrationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	if _, err := admin.Exec("SELECT pg_terminate_backend($1)", pid); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := serve(h, "GET", "/api/users/1", ""); rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want the read retried on a fresh connection: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestIntegrationEmptyResultsAreArrays(t *testing.T) {
+	cfg := useDatabase(t)
+	h := newTestHandler(cfg)
+
+	for _, path := range []string{"/api/users", "/api/users?fields=id,name", "/api/users/search?q=nobody"} {
+		if rec := serve(h, "GET", path, ""); strings.TrimSpace(rec.Body.String()) != "[]" {
+			t.Errorf("GET %s = %s, want []", path, rec.Body)
+		}
+	}
+}
+
+func TestIntegrationBulkUpdate(t *testing.T) {
+	cfg := useDatabase(t)
+	h := newTestHandler(cfg)
+	for _, name := range []string{"Ada Lovelace", "Grace Hopper", "Alan Turing"} {
+		serve(h, "POST", "/api/users", fmt.Sprintf(`{"name": %q}`, name))
+	}
+
+	rec := serve(h, "PATCH", "/api/users/bulk", `{"ids": [1, 3, 99], "name": "Anonymous"}`)
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"updated":2}` {
+		t.Fatalf("got %d %s, want 200 {\"updated\":2}", rec.Code, rec.Body)
+	}
+	for id, want := range map[int]string{1: "Anonymous", 2: "Grace Hopper", 3: "Anonymous"} {
+		if u, err := store.Get(context.Background(), id); err != nil || u.Name != want {
+			t.Errorf("user %d = %q, %v; want %q", id, u.Name, err, want)
+		}
+	}
+}
+
+func TestIntegrationManualMigrate(t *testing.T) {
+	cfg := useDatabase(t, "DB_SCHEMA", "manual_migrate", "MIGRATE_ON_START", "false", "ADMIN_TOKEN", "s3cret")
+	// 👇 Start from an empty schema, whatever earlier runs left behind
+	if _, err := db.Exec("DROP SCHEMA manual_migrate CASCADE; CREATE SCHEMA manual_migrate"); err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler(cfg)
+	all, _ := loadMigrations()
+	latest, _ := latestMigration()
+
+	migrate := func() (applied []string, version int) {
+		t.Helper()
+		rec := serve(h, "POST", "/admin/migrate", "", "Authorization", "Bearer s3cret")
+		var resp struct {
+			Applied          []string `json:"applied"`
+			MigrationVersion int      `json:"migration_version"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("migrate: status = %d: %s", rec.Code, rec.Body)
+		}
+		return resp.Applied, resp.MigrationVersion
+	}
+
+	if applied, version := migrate(); len(applied) != len(all) || version != latest {
+		t.Errorf("first migrate applied %v up to version %d, want all %d migrations up to %d", applied, version, len(all), latest)
+	}
+	if rec := serve(h, "POST", "/api/users", `{"name": "Ada"}`); rec.Code != http.StatusCreated {
+		t.Errorf("create after migrating: status = %d: %s", rec.Code, rec.Body)
+	}
+	if applied, version := migrate(); len(applied) != 0 || version != latest {
+		t.Errorf("second migrate applied %v up to version %d, want a no-op at %d", applied, version, latest)
+	}
+}
+
+// runMain starts the server through main, in a child process pointed at the
+// test container with env (key, value pairs) on top, and returns its base
+// URL once it answers. It is stopped with SIGTERM when the test ends.
+func runMain(t *testing.T, env ...string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := fmt.Sprint(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), "BACKEND_RUN_MAIN=1", "PORT="+port, "DATABASE_URL="+integrationURL)
+	for i := 0; i+1 < len(env); i += 2 {
+		cmd.Env = append(cmd.Env, env[i]+"="+env[i+1])
+	}
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	stop := func() error {
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case err := <-exited:
+			return err
+		case <-time.After(15 * time.Second):
+			cmd.Process.Kill()
+			<-exited
+			return errors.New("did not stop within 15s of SIGTERM")
+		}
+	}
+
+	base := "http://127.0.0.1:" + port
+	for deadline := time.Now().Add(15 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		select {
+		case err := <-exited:
+			t.Fatalf("server exited during startup (%v):\n%s", err, &output)
+		default:
+		}
+		if resp, err := http.Get(base + "/health"); err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			stop()
+			t.Fatalf("server did not answer within 15s:\n%s", &output)
+		}
+	}
+	t.Cleanup(func() {
+		if err := stop(); err != nil {
+			t.Errorf("server shutdown: %v\n%s", err, &output)
+		}
+	})
+	return base
+}
+
+// call sends a request to the server started by runMain
+func call(t *testing.T, method, target, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	return resp.StatusCode, buf.String()
+}
+
+func TestIntegrationStartupWithPendingMigrations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test: needs Docker")
+	}
+	pool, err := sql.Open("postgres", integrationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if _, err := pool.Exec("DROP SCHEMA IF EXISTS pending_startup CASCADE"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing exists in the schema yet, not even feature_flags
+	base := runMain(t, "DB_SCHEMA", "pending_startup", "MIGRATE_ON_START", "false", "ADMIN_TOKEN", "s3cret")
+
+	if code, body := call(t, "GET", base+"/readyz", ""); code != http.StatusOK || !strings.Contains(body, "migrations pending") {
+		t.Errorf("readyz before migrating: %d %s, want 200 with migrations pending", code, body)
+	}
+	if code, body := call(t, "GET", base+"/admin/flags", ""); code != http.StatusOK || !strings.Contains(body, `"fts_search":true`) {
+		t.Errorf("features before migrating: %d %s, want the defaults", code, body)
+	}
+
+	if code, body := call(t, "POST", base+"/admin/migrate", ""); code != http.StatusOK {
+		t.Fatalf("migrate: %d %s", code, body)
+	}
+	if code, body := call(t, "PUT", base+"/admin/flags", `{"fts_search": false}`); code != http.StatusOK {
+		t.Errorf("set flags after migrating: %d %s", code, body)
+	}
+	if code, body := call(t, "POST", base+"/api/users", `{"name": "Ada"}`); code != http.StatusCreated {
+		t.Errorf("create after migrating: %d %s", code, body)
+	}
+	if code, body := call(t, "GET", base+"/readyz", ""); code != http.StatusOK || strings.Contains(body, "migrations pending") {
+		t.Errorf("readyz after migrating: %d %s", code, body)
+	}
+}
@@ -1,41 +1,48 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
-
-	_ "github.com/lib/pq"
 )
 
-// User represents a user in our database
-type User struct {
-	ID        int       `json:"id"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
-}
-
 var db *sql.DB
 
 func main() {
-	// Get database connection info from environment variables
-	// 👇 These come from our Secret and ConfigMap!
-	dbHost := os.Getenv("DB_HOST")
-	dbUser := os.Getenv("POSTGRES_USER")
-	dbPassword := os.Getenv("POSTGRES_PASSWORD")
-	dbName := os.Getenv("POSTGRES_DB")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal("Invalid configuration:", err)
+	}
 
-	// Build connection string
-	connStr := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=5432 sslmode=disable",
-		dbHost, dbUser, dbPassword, dbName)
+	if cfg.DevMode {
+		log.Println("⚠️⚠️⚠️ DEV_MODE is on: unset DB_HOST/POSTGRES_* fall back to a local postgres@localhost/postgres. Never use it in production!")
+	}
+
+	jsonCamelCase = cfg.JSONNaming == "camel"
+	timeFormat = cfg.TimeFormat
+	prettyJSON = cfg.PrettyJSON
+	envelopeResponses = cfg.EnvelopeResponses
+	trustedProxies = cfg.TrustedProxies
+	logBodies = cfg.Debug
+	pageSizeDefault, pageSizeMax = cfg.PageSizeDefault, cfg.PageSizeMax
+	strictPagination = cfg.StrictPagination
+	readyFailureThreshold = int64(cfg.ReadyFailureThreshold)
+	basePath = cfg.BasePath
+	testEndpointsEnabled = cfg.AppEnv != "production"
+	maintenanceMode.Store(cfg.MaintenanceMode)
+	readOnly = cfg.ReadOnly
+	if readOnly {
+		log.Println("🔒 READ_ONLY is set, all writes outside /admin/* will be rejected")
+	}
 
 	// Connect to database
-	var err error
-	db, err = sql.Open("postgres", connStr)
+	db, err = openDB(cfg)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
@@ -43,115 +50,303 @@ func main() {
 
 	// Test the connection
 	err = db.Ping()
+	if isAuthFailure(err) {
+		log.Fatal("Database rejected our credentials, check POSTGRES_USER/POSTGRES_PASSWORD (or DATABASE_URL):", err)
+	}
 	if err != nil {
 		log.Fatal("Failed to ping database:", err)
 	}
 	log.Println("✅ Connected to database successfully!")
 
+	// 👇 Optional read replica; without one, reads share the primary pool
+	replica, err := openReadDB(cfg)
+	if err != nil {
+		log.Fatal("Failed to connect to read replica:", err)
+	}
+	if replica != nil {
+		defer replica.Close()
+		if err := replica.Ping(); err != nil {
+			log.Fatal("Failed to ping read replica:", err)
+		}
+		log.Println("✅ Connected to read replica successfully!")
+	}
+
 	// Initialize database (create table and sample data)
-	initDatabase()
+	initDatabase(cfg)
+	dbBreaker = newDBBreaker(cfg.DBBreakerFailures, cfg.DBBreakerCooldown)
+	store = &postgresStore{
+		db:             db,
+		replica:        replica,
+		retryReads:     cfg.DBRetryReads,
+		tagQueries:     cfg.DBQueryTags,
+		acquireTimeout: cfg.DBAcquireTimeout,
+		queryTimeout:   cfg.DBQueryTimeout,
+	}
+	if cfg.UsersCacheTTL > 0 {
+		store = &cachedStore{UserStore: store, cache: newLRUCache(cfg.UsersCacheSize, cfg.UsersCacheTTL)}
+		log.Printf("✅ Caching user lists for %s\n", cfg.UsersCacheTTL)
+	}
+
+	flags.SetDefaults(cfg.FeatureFlags)
+	// 👇 With MIGRATE_ON_START=false the feature_flags table may not exist
+	// yet; start on the defaults so POST /admin/migrate can still be called
+	err = flags.Load(context.Background())
+	if !cfg.MigrateOnStart && isUndefinedTable(err) {
+		log.Println("⏸️ No feature_flags table until migrations are applied, using the default flags")
+	} else if err != nil {
+		log.Fatal("Failed to load feature flags:", err)
+	}
+
+	// Background jobs: keep pooled connections fresh, the users_total gauge
+	// accurate and feature flags in sync with other replicas
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	refreshUsersTotal(jobsCtx)
+	jobs := []<-chan struct{}{
+		every(jobsCtx, cfg.DBPingInterval, pingDatabase),
+		every(jobsCtx, cfg.UsersGaugeInterval, refreshUsersTotal),
+		every(jobsCtx, cfg.FlagsRefreshInterval, refreshFlags),
+	}
+	if cfg.StatsdAddr != "" {
+		flusher, err := newStatsdFlusher(cfg.StatsdAddr, cfg.StatsdPrefix, db)
+		if err != nil {
+			log.Fatal("Invalid configuration: STATSD_ADDR:", err)
+		}
+		jobs = append(jobs, every(jobsCtx, cfg.StatsdInterval, flusher.flush))
+		log.Printf("📈 Sending StatsD metrics to %s every %s\n", cfg.StatsdAddr, cfg.StatsdInterval)
+	}
+
+	// Dependencies checked by the readiness probe. Database pings are cached
+	// briefly so frequent probes across replicas don't each hit Postgres.
+	readinessChecks = []dependencyCheck{
+		{Name: "database", Critical: true, Timeout: cfg.ReadyCheckTimeout, Check: cacheCheck(db.PingContext, cfg.ReadyCheckCacheTTL)},
+	}
+	if replica != nil {
+		// Every read goes to the replica, so we can't serve without it
+		readinessChecks = append(readinessChecks, dependencyCheck{
+			Name: "read_replica", Critical: true, Timeout: cfg.ReadyCheckTimeout, Check: cacheCheck(replica.PingContext, cfg.ReadyCheckCacheTTL),
+		})
+	}
+	// 👇 Only critical when asked: by default a stale schema is reported but
+	// the pod keeps serving
+	readinessChecks = append(readinessChecks, dependencyCheck{
+		Name: "migrations", Critical: cfg.ReadyRequireMigrations, Timeout: cfg.ReadyCheckTimeout,
+		Check: cacheCheck(checkMigrations, cfg.ReadyCheckCacheTTL),
+	})
+	if cfg.ReadyCheckTempDir {
+		// Nothing writes temp files yet, so a failure only degrades us
+		readinessChecks = append(readinessChecks, dependencyCheck{
+			Name: "temp_dir", Timeout: cfg.ReadyCheckTimeout, Check: checkTempDir,
+		})
+	}
 
 	// Set up HTTP routes
-	http.HandleFunc("/health", healthHandler)
-	http.HandleFunc("/api/test-db", testDBHandler)
-	http.HandleFunc("/api/users", usersHandler)
+	rt := newRouter()
+	registerRoutes(rt, cfg)
+	if cfg.AdminToken == "" {
+		log.Println("🔒 ADMIN_TOKEN not set, /admin/* endpoints are disabled")
+		if !cfg.MigrateOnStart {
+			log.Println("⚠️ MIGRATE_ON_START=false without ADMIN_TOKEN: nothing can apply migrations")
+		}
+	}
+	if cfg.Debug {
+		log.Println("🐛 Debug endpoints enabled at /debug/*")
+	}
+
+	for pattern := range cfg.RouteTimeouts {
+		if !rt.registered(pattern) {
+			log.Fatalf("Invalid configuration: ROUTE_TIMEOUTS names %q, which isn't a route (see GET /api/routes)", pattern)
+		}
+	}
+	for pattern := range cfg.RouteBodyLimits {
+		if !rt.registered(pattern) {
+			log.Fatalf("Invalid configuration: ROUTE_BODY_LIMITS names %q, which isn't a route (see GET /api/routes)", pattern)
+		}
+	}
 
 	// Start server
-	port := ":3000"
-	log.Printf("🚀 Backend API listening on port %s\n", port)
-	log.Fatal(http.ListenAndServe(port, nil))
+	srv := &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: withMiddleware(rt, cfg),
+	}
+	go func() {
+		log.Printf("🚀 Backend API listening on port %s\n", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Server failed:", err)
+		}
+	}()
+
+	// Wait for Kubernetes (or Ctrl+C) to ask us to stop, or for Postgres to
+	// reject our credentials
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	exitCode := waitForStop(ctx)
+
+	// 👇 Fail readiness right away so Kubernetes stops routing to us, and
+	// keep serving for ShutdownDelay while it catches up
+	shuttingDown.Store(true)
+	if cfg.ShutdownDelay > 0 {
+		log.Printf("🛑 Received shutdown signal, unready for %s before draining\n", cfg.ShutdownDelay)
+		time.Sleep(cfg.ShutdownDelay)
+	}
+
+	shutdown(srv, cfg.ShutdownTimeout)
+	stopJobs()
+	for _, done := range jobs {
+		<-done
+	}
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
+// registerRoutes mounts every endpoint on rt; the admin and debug ones only
+// when ADMIN_TOKEN and DEBUG enable them
+func registerRoutes(rt *router, cfg Config) {
+	// 👇 We use our own mux so nothing gets exposed by accident (expvar
+	// registers itself on http.DefaultServeMux). Patterns use Go 1.22's
+	// "METHOD /path/{param}" syntax; read params with r.PathValue("param").
+	// Register through rt so the route shows up in /api/routes.
+	rt.HandleFunc("GET "+cfg.HealthPath, "Liveness probe", healthHandler)
+	rt.HandleFunc("GET "+cfg.ReadyPath, "Readiness probe with per-dependency status", readyzHandler)
+	rt.HandleFunc("GET /version", "Applied schema version and newest embedded migration", versionHandler)
+	rt.HandleFunc("GET /stats", "In-flight requests, requests served, uptime and goroutines", statsHandler)
+	rt.HandleFunc("GET /api/routes", "This list of routes", rt.routesHandler)
+	rt.HandleFunc("GET /api/test-db", "Check the database connection", testDBHandler)
+	rt.HandleFunc("GET /api/ping", "Round-trip time of a database ping", pingHandler)
+	rt.HandleFunc("GET /api/time", "Server and database clocks and the skew between them", timeHandler)
+	rt.HandleFunc("GET /api/users", "List users (limit, offset, sort, fields)", usersHandler)
+	rt.HandleFunc("POST /api/users", "Create a user", createUserHandler)
+	rt.HandleFunc("PATCH /api/users", "Rename many users in one transaction", renameUsersHandler)
+	rt.HandleFunc("PATCH /api/users/bulk", "Give many users the same name at once", bulkUpdateUsersHandler)
+	rt.HandleFunc("GET /api/users/extremes", "Oldest and newest users", userExtremesHandler)
+	rt.HandleFunc("GET /api/users/recent-count", "Count users created within ?window= (default 24h)", recentCountHandler)
+	rt.HandleFunc("GET /api/users/search", "Full-text search on names, best matches first (q, limit, offset)", searchUsersHandler)
+	rt.HandleFunc("POST /api/users/search", "Find users matching a JSON filter (name, created range, sort, limit, offset)", filterUsersHandler)
+	rt.HandleFunc("GET /api/users/by-name", "Find a user by name, ignoring case", getUserByNameHandler)
+	rt.HandleFunc("PUT /api/users/by-name/{name}", "Create or update a user by name", upsertUserByNameHandler)
+	rt.HandleFunc("GET /api/users/{id}", "Get a user", getUserHandler)
+	rt.HandleFunc("PUT /api/users/{id}", "Rename a user", updateUserHandler)
+	rt.HandleFunc("PATCH /api/users/{id}", "Rename a user", updateUserHandler)
+	rt.HandleFunc("DELETE /api/users/{id}", "Delete a user", deleteUserHandler)
+	rt.HandleFunc("PATCH /api/users/{id}/created-at", "Backdate a user (not in production)", setCreatedAtHandler)
+	if cfg.SchemaRequireAdmin {
+		rt.HandleFunc("GET /api/schema", "Columns of the users table (admin token)", requireAdmin(cfg.AdminToken, schemaHandler))
+	} else {
+		rt.HandleFunc("GET /api/schema", "Columns of the users table", schemaHandler)
+	}
+	rt.Handle("GET /metrics", "Prometheus metrics", metricsHandler(cfg))
+
+	if cfg.AdminToken != "" {
+		registerAdminRoutes(rt, cfg)
+	}
+	if cfg.Debug {
+		registerDebugRoutes(rt, cfg)
+	}
+}
+
+// withMiddleware wraps rt's routes in our middleware, innermost first.
+// Everything that sets headers for the handlers to read sits inside
+// limitDuration's buffered writer.
+func withMiddleware(rt *router, cfg Config) http.Handler {
+	var handler http.Handler = rejectWrites(rt.mux)
+	handler = limitBodySize(handler, cfg.MaxBodyBytes, cfg.RouteBodyLimits, rt.pattern)
+	handler = negotiateErrorLanguage(handler)
+	handler = negotiatePrettyJSON(handler)
+	handler = logRequestBodies(handler)
+	handler = logServerErrors(handler)
+	handler = withRequestID(handler)
+	handler = limitDuration(handler, cfg.MaxRequestDuration, cfg.RouteTimeouts, rt.pattern, cfg.HealthPath, cfg.ReadyPath)
+	handler = withCORS(handler, newCORSPolicy(cfg)) // outside, so timeouts carry CORS headers too
+	handler = countRequests(handler)
+	handler = stripBasePath(handler)
+	return handler
+}
+
+// waitForStop blocks until ctx is done (a shutdown signal, exit code 0) or
+// Postgres rejects our credentials (exit code 1, so Kubernetes restarts us)
+func waitForStop(ctx context.Context) int {
+	select {
+	case <-ctx.Done():
+		return 0
+	case <-credentialsRejected:
+		return 1
+	}
+}
+
+// shutdown drains in-flight requests, force-closing whatever is left after timeout
+func shutdown(srv *http.Server, timeout time.Duration) {
+	log.Printf("🛑 Shutting down, draining requests for up to %s...\n", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		// 👇 A stuck request (e.g. a long poll) must not keep the pod alive
+		// past its termination grace period
+		log.Println("⚠️ Shutdown timed out, forcing remaining connections closed:", err)
+		srv.Close()
+		return
+	}
+	log.Println("✅ Shutdown completed cleanly")
 }
 
 // healthHandler returns a simple health check
 func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+	writeJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
 }
 
 // testDBHandler tests the database connection
 func testDBHandler(w http.ResponseWriter, r *http.Request) {
 	var now time.Time
-	err := db.QueryRow("SELECT NOW()").Scan(&now)
-
-	w.Header().Set("Content-Type", "application/json")
-
+	err := db.QueryRowContext(r.Context(), "SELECT NOW()").Scan(&now)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"message": "Database connection failed",
-			"error":   err.Error(),
-		})
+		writeDBError(w, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"message":   "Database connection successful!",
 		"timestamp": now,
 	})
 }
 
-// usersHandler returns all users from the database
-func usersHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT id, name, created_at FROM users ORDER BY id")
+// pingHandler reports how long a database ping took, for checking network
+// latency to Postgres from inside the pod. A failed ping is a 503 that still
+// reports how long it took to fail.
+func pingHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	err := db.PingContext(r.Context())
+	latencyMs := float64(time.Since(start).Microseconds()) / 1000
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		log.Println("❌ Database ping failed:", err)
+		checkCredentials(err)
+		w.Header().Set("Retry-After", dbRetryAfter)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"error":         localize(w, "database ping failed"),
+			"db_latency_ms": latencyMs,
+		})
 		return
 	}
-	defer rows.Close()
-
-	// Collect all users
-	var users []User
-	for rows.Next() {
-		var u User
-		if err := rows.Scan(&u.ID, &u.Name, &u.CreatedAt); err != nil {
-			log.Println("Error scanning row:", err)
-			continue
-		}
-		users = append(users, u)
-	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	writeJSON(w, http.StatusOK, map[string]float64{"db_latency_ms": latencyMs})
 }
 
-// initDatabase creates the table and inserts sample data
-func initDatabase() {
-	// Create table if it doesn't exist
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS users (
-		id SERIAL PRIMARY KEY,
-		name VARCHAR(100) NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	)`
-
-	_, err := db.Exec(createTableSQL)
+// timeHandler compares our clock with the database's NOW(). The server time
+// is taken halfway through the query, so network latency doesn't count as
+// skew; a positive skew_ms means the database clock is ahead.
+func timeHandler(w http.ResponseWriter, r *http.Request) {
+	var dbTime time.Time
+	start := time.Now()
+	err := db.QueryRowContext(r.Context(), "SELECT NOW()").Scan(&dbTime)
 	if err != nil {
-		log.Fatal("Failed to create table:", err)
-	}
-
-	// Check if we need to insert sample data
-	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
-	if err != nil {
-		log.Fatal("Failed to count users:", err)
-	}
-
-	if count == 0 {
-		insertSQL := `
-		INSERT INTO users (name) VALUES
-			('Jabril'),
-			('Platform Engineer'),
-			('Go Developer'),
-			('Kubernetes Master')`
-
-		_, err = db.Exec(insertSQL)
-		if err != nil {
-			log.Fatal("Failed to insert sample data:", err)
-		}
-		log.Println("✅ Sample data inserted!")
+		writeDBError(w, err)
+		return
 	}
+	serverTime := start.Add(time.Since(start) / 2)
 
-	log.Println("✅ Database initialized successfully!")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"server_time": serverTime.UTC(),
+		"db_time":     dbTime.UTC(),
+		"skew_ms":     float64(dbTime.Sub(serverTime).Microseconds()) / 1000,
+	})
 }
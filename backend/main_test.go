@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConfig loads the config like main does, from env (key, value pairs)
+// on top of a DATABASE_URL so the DB variables aren't required
+func testConfig(t *testing.T, env ...string) Config {
+	t.Helper()
+	t.Setenv("DATABASE_URL", "postgres://test@localhost/test")
+	for i := 0; i+1 < len(env); i += 2 {
+		t.Setenv(env[i], env[i+1])
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	return cfg
+}
+
+// newTestHandler builds the server's full handler, routes and middleware,
+// for cfg
+func newTestHandler(cfg Config) http.Handler {
+	rt := newRouter()
+	registerRoutes(rt, cfg)
+	return withMiddleware(rt, cfg)
+}
+
+// serve sends a request to h and returns the response. A non-empty body is
+// sent as JSON; headers are key, value pairs.
+func serve(h http.Handler, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// startServer serves h on a free local port, returning the server and its
+// base URL
+func startServer(t *testing.T, h http.Handler) (*http.Server, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: h}
+	go srv.Serve(ln)
+	return srv, "http://" + ln.Addr().String()
+}
+
+func TestShutdownForcesSlowRequests(t *testing.T) {
+	logs := captureLog(t)
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	srv, url := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release // a long poll that ignores shutdown
+	}))
+
+	failed := make(chan error, 1)
+	go func() {
+		_, err := http.Get(url)
+		failed <- err
+	}()
+	<-started
+
+	start := time.Now()
+	shutdown(srv, 50*time.Millisecond)
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("shutdown took %s, want about 50ms", took)
+	}
+	if err := <-failed; err == nil {
+		t.Error("the slow request completed, want its connection closed")
+	}
+	if !strings.Contains(logs.String(), "forcing remaining connections closed") {
+		t.Errorf("log = %q, want the forced shutdown reported", logs)
+	}
+}
+
+func TestShutdownDrainsCleanly(t *testing.T) {
+	logs := captureLog(t)
+	started := make(chan struct{})
+	srv, url := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("done"))
+	}))
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	<-started
+
+	shutdown(srv, time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("in-flight request failed: %v", err)
+	}
+	if !strings.Contains(logs.String(), "Shutdown completed cleanly") {
+		t.Errorf("log = %q, want a clean shutdown reported", logs)
+	}
+}
+
+// useDB points the global db at a stub connector for the test
+func useDB(t *testing.T, c driver.Connector) {
+	t.Helper()
+	prev := db
+	db = sql.OpenDB(c)
+	t.Cleanup(func() {
+		db.Close()
+		db = prev
+	})
+}
+
+func TestPingReportsLatency(t *testing.T) {
+	h := newTestHandler(testConfig(t))
+	for _, tc := range []struct {
+		name string
+		conn stubRows
+		want int
+	}{
+		{"reachable", stubRows{}, http.StatusOK},
+		{"unreachable", stubRows{connectErr: errors.New("connection refused")}, http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			useDB(t, tc.conn)
+			rec := serve(h, "GET", "/api/ping", "")
+			var resp map[string]interface{}
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+			if latency, ok := resp["db_latency_ms"].(float64); !ok || latency < 0 {
+				t.Errorf("db_latency_ms = %v, want a number: %s", resp["db_latency_ms"], rec.Body)
+			}
+		})
+	}
+}
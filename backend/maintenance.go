@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// maintenanceMode rejects writes while reads keep working. It starts from
+// MAINTENANCE_MODE and can be flipped at runtime via PUT /admin/maintenance.
+var maintenanceMode atomic.Bool
+
+// maintenanceRetryAfter is the Retry-After (seconds) on writes rejected
+// during maintenance. We can't know when it ends, so it's a fixed guess.
+const maintenanceRetryAfter = "60"
+
+// readOnly is READ_ONLY: writes are off for the life of the process, e.g.
+// for a deployment serving from a replica. Unlike maintenanceMode it can't
+// be toggled at runtime, and readiness isn't affected.
+var readOnly bool
+
+// readOnlyPosts are POST routes that only read, taking their query in the
+// body, so they keep working when writes are rejected
+var readOnlyPosts = []string{"/api/users/search"}
+
+// isWrite reports whether r would change data
+func isWrite(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	case http.MethodPost:
+		return !slices.Contains(readOnlyPosts, r.URL.Path)
+	}
+	return true
+}
+
+// rejectWrites answers writes to mux's routes with a 405 in read-only mode
+// and a 503 while maintenance mode is on. /admin/* is exempt so operators
+// keep control (and maintenance mode can still be switched off). Requests no
+// route matches are passed on, so they still get the mux's 404 or 405.
+func rejectWrites(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWrite(r) || strings.HasPrefix(r.URL.Path, "/admin/") {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		if _, pattern := mux.Handler(r); pattern == "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		switch {
+		case readOnly:
+			w.Header().Set("Allow", allowedWhenReadOnly(mux, r))
+			writeError(w, http.StatusMethodNotAllowed, "server is read-only")
+		case maintenanceMode.Load():
+			w.Header().Set("Retry-After", maintenanceRetryAfter)
+			writeError(w, http.StatusServiceUnavailable, "maintenance in progress")
+		default:
+			mux.ServeHTTP(w, r)
+		}
+	})
+}
+
+// allowedWhenReadOnly lists the methods r's path still accepts while writes
+// are off: GET and HEAD if it has a GET route, POST if it is a read-only POST
+func allowedWhenReadOnly(mux *http.ServeMux, r *http.Request) string {
+	var allowed []string
+	probe := r.Clone(r.Context())
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		probe.Method = method
+		if _, pattern := mux.Handler(probe); pattern == "" || isWrite(probe) {
+			continue
+		}
+		allowed = append(allowed, method)
+		if method == http.MethodGet {
+			allowed = append(allowed, http.MethodHead)
+		}
+	}
+	return strings.Join(append(allowed, http.MethodOptions), ", ")
+}
+
+// maintenanceHandler reports whether maintenance mode is on
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]bool{"enabled": maintenanceMode.Load()})
+}
+
+// setMaintenanceHandler turns maintenance mode on or off with {"enabled": bool}
+func setMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if req.Enabled == nil {
+		writeError(w, http.StatusBadRequest, `body must be {"enabled": true|false}`)
+		return
+	}
+
+	maintenanceMode.Store(*req.Enabled)
+	log.Printf("🚧 Maintenance mode set to %t\n", *req.Enabled)
+	writeJSON(w, http.StatusOK, map[string]bool{"enabled": *req.Enabled})
+}
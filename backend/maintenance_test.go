@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSetMaintenance(t *testing.T) {
+	t.Cleanup(func() { maintenanceMode.Store(false) })
+	h := newTestHandler(testConfig(t, "ADMIN_TOKEN", "s3cret"))
+	auth := []string{"Authorization", "Bearer s3cret"}
+
+	for _, body := range []string{`{}`, `{"enabled": "yes"}`, `{"enabled": true, "until": "noon"}`, `{"enabled": true} {}`} {
+		if rec := serve(h, "PUT", "/admin/maintenance", body, auth...); rec.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s: status = %d, want 400", body, rec.Code)
+		}
+	}
+	if rec := serve(h, "PUT", "/admin/maintenance", "on", append(auth, "Content-Type", "text/plain")...); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain: status = %d, want 415", rec.Code)
+	}
+	if maintenanceMode.Load() {
+		t.Fatal("a rejected request turned maintenance mode on")
+	}
+
+	if rec := serve(h, "PUT", "/admin/maintenance", `{"enabled": true}`, auth...); rec.Code != http.StatusOK || !maintenanceMode.Load() {
+		t.Errorf("enable: status = %d, enabled = %t", rec.Code, maintenanceMode.Load())
+	}
+	if rec := serve(h, "GET", "/admin/maintenance", "", auth...); rec.Body.String() != `{"enabled":true}`+"\n" {
+		t.Errorf("GET = %q", rec.Body)
+	}
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	fs := useFakeStore(t, "Ada")
+	useChecks(t)
+	cfg := testConfig(t, "READ_ONLY", "true", "ADMIN_TOKEN", "s3cret")
+	readOnly = cfg.ReadOnly
+	t.Cleanup(func() { readOnly = false })
+	h := newTestHandler(cfg)
+
+	for _, req := range [][3]string{
+		{"POST", "/api/users", `{"name": "Grace"}`},
+		{"PUT", "/api/users/1", `{"name": "Grace"}`},
+		{"PATCH", "/api/users/1", `{"name": "Grace"}`},
+		{"DELETE", "/api/users/1", ""},
+		{"PATCH", "/api/users/bulk", `{"ids": [1], "name": "Grace"}`},
+	} {
+		rec := serve(h, req[0], req[1], req[2])
+		if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") == "" || !strings.Contains(rec.Body.String(), "read-only") {
+			t.Errorf("%s %s: got %d %s, want 405 read-only", req[0], req[1], rec.Code, rec.Body)
+		}
+	}
+	if got := serve(h, "DELETE", "/api/users/1", "").Header().Get("Allow"); got != "GET, HEAD, OPTIONS" {
+		t.Errorf("Allow = %q, want the methods /api/users/1 still accepts", got)
+	}
+	// Writes no route serves are still 404 (or the mux's own 405)
+	if rec := serve(h, "POST", "/api/nope", `{}`); rec.Code != http.StatusNotFound {
+		t.Errorf("POST /api/nope: status = %d, want 404", rec.Code)
+	}
+	if rec := serve(h, "DELETE", "/api/users", ""); rec.Code != http.StatusMethodNotAllowed || strings.Contains(rec.Body.String(), "read-only") {
+		t.Errorf("DELETE /api/users: got %d %s, want the mux's 405", rec.Code, rec.Body)
+	}
+	if u, err := fs.Get(context.Background(), 1); err != nil || u.Name != "Ada" {
+		t.Errorf("user 1 = %+v, %v; want Ada untouched", u, err)
+	}
+
+	for _, req := range [][3]string{
+		{"GET", "/api/users", ""},
+		{"GET", "/api/users/1", ""},
+		{"HEAD", "/api/users/1", ""},
+		{"POST", "/api/users/search", `{"name_contains": "a"}`},
+		{"GET", cfg.ReadyPath, ""},
+		{"GET", "/admin/maintenance", ""},
+	} {
+		if rec := serve(h, req[0], req[1], req[2], "Authorization", "Bearer s3cret"); rec.Code != http.StatusOK {
+			t.Errorf("%s %s: status = %d, want 200", req[0], req[1], rec.Code)
+		}
+	}
+}
+
+func TestMaintenanceOnlyRejectsRoutes(t *testing.T) {
+	useFakeStore(t, "Ada")
+	maintenanceMode.Store(true)
+	t.Cleanup(func() { maintenanceMode.Store(false) })
+	h := newTestHandler(testConfig(t))
+
+	if rec := serve(h, "PUT", "/api/users/1", `{"name": "Grace"}`); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("PUT /api/users/1: status = %d, want 503", rec.Code)
+	}
+	if rec := serve(h, "POST", "/api/nope", `{}`); rec.Code != http.StatusNotFound {
+		t.Errorf("POST /api/nope: status = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// usersTotal is the live user count. Create/delete adjust it immediately and
+// refreshUsersTotal periodically recounts to correct any drift (e.g. rows
+// changed by another replica or directly in SQL).
+var usersTotal = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "users_total",
+	Help: "Number of users in the database.",
+})
+
+// dbQueryErrors counts failed store queries, so a burst of constraint
+// violations can be told apart from the database going away
+var dbQueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "db_query_errors_total",
+	Help: "Failed database queries by store operation and error class.",
+}, []string{"operation", "class"})
+
+// dbConnAcquire is how long store queries waited for a pooled connection
+var dbConnAcquire = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "db_conn_acquire_seconds",
+	Help:    "Time spent waiting for a database connection from the pool.",
+	Buckets: []float64{.001, .005, .01, .05, .1, .5, 1, 2.5, 5},
+})
+
+// observeQuery records err (if it is a real failure) and returns it unchanged
+func observeQuery(op string, err error) error {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		dbQueryErrors.WithLabelValues(op, classifyDBError(err)).Inc()
+	}
+	return err
+}
+
+// classifyDBError buckets err into circuit_open, pool_timeout, connection,
+// constraint, timeout, canceled or other
+func classifyDBError(err error) string {
+	if isBreakerOpen(err) {
+		return "circuit_open"
+	}
+	if errors.Is(err, errAcquireTimeout) {
+		return "pool_timeout"
+	}
+	if isConnectionError(err) {
+		return "connection"
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code.Class() == "23": // integrity_constraint_violation
+			return "constraint"
+		case pqErr.Code == "57014", pqErr.Code == "55P03": // statement or lock timeout
+			return "timeout"
+		}
+	}
+	return "other"
+}
+
+// refreshUsersTotal sets usersTotal from SELECT COUNT(*)
+func refreshUsersTotal(ctx context.Context) {
+	n, err := store.Count(ctx)
+	if err != nil {
+		log.Println("⚠️ Failed to refresh users_total:", err)
+		return
+	}
+	usersTotal.Set(float64(n))
+}
+
+// metricsHandler serves /metrics, open by default for in-cluster scraping.
+// Setting METRICS_TOKEN (bearer) and/or METRICS_USER + METRICS_PASSWORD
+// (basic auth) requires one of those credentials.
+func metricsHandler(cfg Config) http.Handler {
+	h := promhttp.Handler()
+	if cfg.MetricsToken == "" && cfg.MetricsUser == "" {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.MetricsToken != "" && hasBearerToken(r, cfg.MetricsToken) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if user, pass, ok := r.BasicAuth(); ok && cfg.MetricsUser != "" &&
+			secureCompare(user, cfg.MetricsUser) && secureCompare(pass, cfg.MetricsPassword) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if cfg.MetricsUser != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+		}
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+	})
+}
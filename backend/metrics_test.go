@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsersTotalGauge(t *testing.T) {
+	fs := useFakeStore(t, "Ada", "Grace")
+	h := newTestHandler(testConfig(t))
+
+	refreshUsersTotal(context.Background())
+	if got := gaugeValue(usersTotal); got != 2 {
+		t.Fatalf("after refresh: users_total = %v, want 2", got)
+	}
+
+	serve(h, "POST", "/api/users", `{"name": "Alan"}`)
+	serve(h, "POST", "/api/users", `{"name": "Edsger"}`)
+	if got := gaugeValue(usersTotal); got != 4 {
+		t.Errorf("after 2 inserts: users_total = %v, want 4", got)
+	}
+	serve(h, "DELETE", "/api/users/1", "")
+	if got := gaugeValue(usersTotal); got != 3 {
+		t.Errorf("after a delete: users_total = %v, want 3", got)
+	}
+
+	// A write made behind our back is picked up by the next refresh
+	fs.Create(context.Background(), "Barbara")
+	refreshUsersTotal(context.Background())
+	if got := gaugeValue(usersTotal); got != 4 {
+		t.Errorf("after refresh: users_total = %v, want 4", got)
+	}
+
+	if rec := serve(h, "GET", "/metrics", ""); !strings.Contains(rec.Body.String(), "users_total 4") {
+		t.Error("/metrics doesn't report users_total 4")
+	}
+}
+
+func TestUsersGaugeInterval(t *testing.T) {
+	if got := testConfig(t).UsersGaugeInterval; got != 30*time.Second {
+		t.Errorf("default USERS_GAUGE_INTERVAL = %s, want 30s", got)
+	}
+	if got := testConfig(t, "USERS_GAUGE_INTERVAL", "5s").UsersGaugeInterval; got != 5*time.Second {
+		t.Errorf("USERS_GAUGE_INTERVAL=5s gives %s", got)
+	}
+}
+
+func TestMetricsOpenByDefault(t *testing.T) {
+	h := newTestHandler(testConfig(t))
+	if rec := serve(h, "GET", "/metrics", ""); rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200 without credentials configured", rec.Code)
+	}
+}
+
+func TestMetricsBearerToken(t *testing.T) {
+	h := newTestHandler(testConfig(t, "METRICS_TOKEN", "scrape"))
+
+	rec := serve(h, "GET", "/metrics", "")
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") != `Bearer realm="metrics"` {
+		t.Errorf("no token: status = %d, WWW-Authenticate = %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+	if rec := serve(h, "GET", "/metrics", "", "Authorization", "Bearer wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status = %d, want 401", rec.Code)
+	}
+	if rec := serve(h, "GET", "/metrics", "", "Authorization", "Bearer scrape"); rec.Code != http.StatusOK {
+		t.Errorf("right token: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestMetricsBasicAuth(t *testing.T) {
+	h := newTestHandler(testConfig(t, "METRICS_USER", "prom", "METRICS_PASSWORD", "pw"))
+	basic := func(user, pass string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+	}
+
+	rec := serve(h, "GET", "/metrics", "")
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") != `Basic realm="metrics"` {
+		t.Errorf("no credentials: status = %d, WWW-Authenticate = %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+	for _, auth := range []string{basic("prom", "wrong"), basic("other", "pw"), "Bearer pw"} {
+		if rec := serve(h, "GET", "/metrics", "", "Authorization", auth); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%q: status = %d, want 401", auth, rec.Code)
+		}
+	}
+	if rec := serve(h, "GET", "/metrics", "", "Authorization", basic("prom", "pw")); rec.Code != http.StatusOK {
+		t.Errorf("right credentials: status = %d, want 200", rec.Code)
+	}
+
+	if err := configError(t, "METRICS_USER", "prom", "METRICS_PASSWORD", ""); err == nil {
+		t.Error("METRICS_USER without METRICS_PASSWORD: no error")
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// basePath is the BASE_PATH prefix we are served under ("" = none). It is
+// stripped before routing and added back to the links we generate.
+var basePath string
+
+// stripBasePath serves next under basePath, 404ing anything outside it.
+// It wraps every other middleware, so they all see unprefixed paths.
+func stripBasePath(next http.Handler) http.Handler {
+	if basePath == "" {
+		return next
+	}
+	strip := http.StripPrefix(basePath, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 👇 Match whole path segments: /backendfoo isn't under /backend
+		switch {
+		case strings.HasPrefix(r.URL.Path, basePath+"/"):
+			strip.ServeHTTP(w, r)
+		case r.URL.Path == basePath:
+			// Served as "/", rather than redirected to a "/" outside basePath
+			r = r.Clone(r.Context())
+			r.URL.Path, r.URL.RawPath = basePath+"/", ""
+			strip.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// countRequests bumps the requests_served counter for every incoming request
+// and tracks how many are in flight. The deferred decrement also runs when a
+// handler panics (net/http recovers those per connection).
+func countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestsServed.Add(1)
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trustedProxies are the proxies allowed to tell us the client's address
+// (TRUST_PROXY). With none, forwarding headers are ignored so clients
+// can't spoof their IP.
+var trustedProxies []netip.Prefix
+
+// isTrustedProxy reports whether addr is one of trustedProxies
+func isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client behind r. When the peer is a
+// trusted proxy, X-Forwarded-For is walked from the right, skipping our own
+// proxies, and the first untrusted hop is the client (hops further left are
+// whatever the client chose to send). X-Real-IP is the fallback.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !isTrustedProxy(peer) {
+		return host
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break // a malformed hop can't be trusted, nor anything before it
+			}
+			if !isTrustedProxy(hop) || i == 0 {
+				return hop.Unmap().String()
+			}
+		}
+	}
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.Unmap().String()
+	}
+	return host
+}
+
+// requestIDHeader carries the request ID in both directions
+const requestIDHeader = "X-Request-ID"
+
+// requestIDPattern is what we accept as a caller-supplied request ID
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestID tags every response with an X-Request-ID, reusing the
+// caller's (e.g. from the ingress) when it looks sane. Handlers find it in
+// w.Header() for logging, so this must wrap them inside limitDuration.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder remembers the status a handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// logServerErrors logs every 5xx with its request ID and the client's
+// address (see clientIP), so a failure in the logs can be traced back to
+// whoever hit it. It reads the ID off w, so it must wrap the handlers
+// inside withRequestID.
+func logServerErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status >= 500 {
+			log.Printf("❌ %s %s answered %d (request %s from %s)\n", r.Method, r.URL.Path, rec.status, w.Header().Get(requestIDHeader), clientIP(r))
+		}
+	})
+}
+
+// newRequestID returns 16 random hex characters
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// limitBodySize caps how much of a request body handlers can read, at
+// routeLimits[route(r)] when the route has one and limit otherwise (0 =
+// unlimited). Reading past the cap fails with an *http.MaxBytesError, which
+// decodeJSON turns into a 413.
+func limitBodySize(next http.Handler, limit int64, routeLimits map[string]int64, route func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := limit
+		if routeLimit, ok := routeLimits[route(r)]; ok {
+			n = routeLimit
+		}
+		if n > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// timeoutBody is what a client gets when limitDuration gives up on a request
+const timeoutBody = `{"error":"request timed out"}`
+
+// timeoutHeaders adds Content-Type and Retry-After to a 503 written without
+// them, which is how TimeoutHandler answers when it gives up. A handler that
+// finishes in time has its headers copied over first, so its own win and
+// other responses (e.g. a bodyless 204) are left alone.
+type timeoutHeaders struct {
+	http.ResponseWriter
+}
+
+func (w timeoutHeaders) WriteHeader(code int) {
+	if code == http.StatusServiceUnavailable {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		if w.Header().Get("Retry-After") == "" {
+			w.Header().Set("Retry-After", dbRetryAfter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w timeoutHeaders) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// limitDuration aborts any request running longer than its limit with a 503
+// and a JSON body, so a handler that blocks can't tie up the client forever.
+// The limit is routeLimits[route(r)] when the route has one (0 = none) and
+// limit otherwise. The exempt paths (health and readiness probes) are never
+// cut off.
+func limitDuration(next http.Handler, limit time.Duration, routeLimits map[string]time.Duration, route func(*http.Request) string, exempt ...string) http.Handler {
+	if limit <= 0 && len(routeLimits) == 0 {
+		return next
+	}
+
+	// 👇 One TimeoutHandler per distinct limit. It writes timeoutBody
+	// without a Content-Type; timeoutHeaders adds it to that 503 only.
+	timed := make(map[time.Duration]http.Handler)
+	addLimit := func(d time.Duration) {
+		if d > 0 && timed[d] == nil {
+			timed[d] = http.TimeoutHandler(next, d, timeoutBody)
+		}
+	}
+	addLimit(limit)
+	for _, d := range routeLimits {
+		addLimit(d)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(exempt, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		d := limit
+		if routeLimit, ok := routeLimits[route(r)]; ok {
+			d = routeLimit
+		}
+		if d <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timed[d].ServeHTTP(timeoutHeaders{w}, r)
+	})
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"log"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// TestRetryAfterOn503 checks that every way we answer 503 tells the client
+// when to come back
+func TestRetryAfterOn503(t *testing.T) {
+	check := func(name string, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: status = %d, want 503", name, rec.Code)
+		} else if rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: 503 without Retry-After", name)
+		}
+	}
+
+	for name, err := range map[string]error{
+		"connection error": driver.ErrBadConn,
+		"acquire timeout":  errAcquireTimeout,
+		"query timeout":    context.DeadlineExceeded,
+		"breaker open":     gobreaker.ErrOpenState,
+	} {
+		useFakeStore(t).err = err
+		check(name, serve(newTestHandler(testConfig(t)), "GET", "/api/users", ""))
+	}
+
+	t.Run("maintenance", func(t *testing.T) {
+		maintenanceMode.Store(true)
+		t.Cleanup(func() { maintenanceMode.Store(false) })
+		useFakeStore(t)
+		check("maintenance", serve(newTestHandler(testConfig(t)), "POST", "/api/users", `{"name": "Ada"}`))
+	})
+
+	t.Run("ping", func(t *testing.T) {
+		prev := db
+		t.Cleanup(func() { db = prev })
+		db, _ = sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable connect_timeout=1")
+		defer db.Close()
+		check("ping", serve(newTestHandler(testConfig(t)), "GET", "/api/ping", ""))
+	})
+
+	t.Run("readiness", func(t *testing.T) {
+		shuttingDown.Store(true)
+		t.Cleanup(func() { shuttingDown.Store(false) })
+		cfg := testConfig(t)
+		check("shutting down", serve(newTestHandler(cfg), "GET", cfg.ReadyPath, ""))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		})
+		h := limitDuration(slow, 10*time.Millisecond, nil, func(*http.Request) string { return "" })
+		check("timeout", serve(h, "GET", "/", ""))
+	})
+}
+
+func TestLimitDurationKeepsHandlerHeaders(t *testing.T) {
+	route := func(*http.Request) string { return "" }
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	rec := serve(limitDuration(ok, time.Second, nil, route), "GET", "/", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Retry-After") != "" {
+		t.Errorf("fast 200: status = %d, Retry-After = %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// A 503 from the handler itself keeps its own Retry-After
+	unavailable := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "42")
+		writeError(w, http.StatusServiceUnavailable, "busy")
+	})
+	rec = serve(limitDuration(unavailable, time.Second, nil, route), "GET", "/", "")
+	if got := rec.Header().Get("Retry-After"); got != "42" {
+		t.Errorf("handler 503: Retry-After = %q, want 42", got)
+	}
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+			writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		case <-r.Context().Done():
+		}
+	})
+	route := func(r *http.Request) string { return r.Method + " " + r.URL.Path }
+	h := limitDuration(slow, time.Second, map[string]time.Duration{
+		"GET /short":     20 * time.Millisecond,
+		"GET /unlimited": 0,
+	}, route, "/healthz")
+
+	start := time.Now()
+	rec := serve(h, "GET", "/short", "")
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != timeoutBody || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("short route: got %d %q (%s), want a JSON 503", rec.Code, rec.Body, rec.Header().Get("Content-Type"))
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("short route took %s, want it cut off at its 20ms limit", elapsed)
+	}
+
+	for _, path := range []string{"/other", "/unlimited", "/healthz"} {
+		if rec := serve(h, "GET", path, ""); rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want 200 within its limit", path, rec.Code)
+		}
+	}
+
+	// Only the timeout's own body is labelled JSON
+	noContent := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rec = serve(limitDuration(noContent, time.Second, nil, route), "DELETE", "/users/1", "")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Content-Type") != "" {
+		t.Errorf("204: got %d with Content-Type %q, want none", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestRouteTimeoutsConfig(t *testing.T) {
+	cfg := testConfig(t, "ROUTE_TIMEOUTS", " GET /api/users=3s, GET /api/users/extremes=30s ")
+	want := map[string]time.Duration{"GET /api/users": 3 * time.Second, "GET /api/users/extremes": 30 * time.Second}
+	if !maps.Equal(cfg.RouteTimeouts, want) {
+		t.Errorf("RouteTimeouts = %v, want %v", cfg.RouteTimeouts, want)
+	}
+	for _, v := range []string{"GET /api/users", "/api/users=3s", "GET /api/users=soon", "GET /api/users=-1s"} {
+		if err := configError(t, "ROUTE_TIMEOUTS", v); err == nil {
+			t.Errorf("ROUTE_TIMEOUTS=%q was accepted", v)
+		}
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	useFakeStore(t, "Ada", "Grace")
+	cfg := testConfig(t, "BASE_PATH", "/backend/")
+	basePath = cfg.BasePath
+	t.Cleanup(func() { basePath = "" })
+	h := newTestHandler(cfg)
+
+	rec := serve(h, "GET", "/backend/api/users?limit=1", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /backend/api/users: status = %d, want 200", rec.Code)
+	}
+	if link := rec.Header().Get("Link"); !strings.Contains(link, "</backend/api/users?limit=1&offset=1>") {
+		t.Errorf("Link = %q, want the next page under /backend", link)
+	}
+	rec = serve(h, "POST", "/backend/api/users", `{"name": "Linus"}`)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Location") != "/backend/api/users/3" {
+		t.Errorf("POST: got %d, Location %q; want 201 /backend/api/users/3", rec.Code, rec.Header().Get("Location"))
+	}
+
+	for _, path := range []string{"/api/users", "/backendapi/users", "/backendfoo", "/backend"} {
+		if rec := serve(h, "GET", path, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status = %d (Location %q), want 404", path, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
+
+// captureLog collects what the test logs
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestServerErrorsLogClientAndRequestID(t *testing.T) {
+	prev := trustedProxies
+	trustedProxies = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	t.Cleanup(func() { trustedProxies = prev })
+	fs := useFakeStore(t, "Ada")
+	h := newTestHandler(testConfig(t))
+	logs := captureLog(t)
+
+	serve(h, "GET", "/api/users", "", "X-Request-ID", "ok-1")
+	if logs.Len() != 0 {
+		t.Errorf("a 200 was logged: %s", logs)
+	}
+
+	fs.err = driver.ErrBadConn
+	serve(h, "GET", "/api/users", "", "X-Request-ID", "req-1", "X-Forwarded-For", "203.0.113.7")
+	want := "GET /api/users answered 503 (request req-1 from 203.0.113.7)"
+	if !strings.Contains(logs.String(), want) {
+		t.Errorf("log = %q, want it to contain %q", logs, want)
+	}
+}
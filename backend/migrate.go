@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationFiles are the numbered SQL migrations shipped inside the binary
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration is one numbered schema change, e.g. 0001_create_users.sql
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// loadMigrations reads the embedded migrations in version order
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, e := range entries {
+		prefix, _, ok := strings.Cut(e.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s must be named NNNN_description.sql", e.Name())
+		}
+		body, err := migrationFiles.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{Version: version, Name: e.Name(), SQL: string(body)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// runMigrations applies every migration newer than the recorded version,
+// each in its own transaction, and returns the ones it applied. Callers
+// hold the initialization lock (withInitLock).
+func runMigrations(ctx context.Context) ([]migration, error) {
+	_, err := db.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := schemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []migration
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := applyMigration(ctx, m); err != nil {
+			return applied, fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+		log.Printf("✅ Applied migration %s\n", m.Name)
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// applyMigration runs one migration and records it atomically
+func applyMigration(ctx context.Context, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", m.Version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// schemaVersion returns the highest applied migration (0 when none are)
+func schemaVersion(ctx context.Context) (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	return int(version.Int64), err
+}
+
+// latestMigration returns the highest embedded migration version
+func latestMigration() (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil || len(migrations) == 0 {
+		return 0, err
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// errMigrationsPending means the schema is older than this binary expects
+var errMigrationsPending = errors.New("migrations pending")
+
+// checkMigrations fails with errMigrationsPending when the database is
+// behind the embedded migrations, e.g. after a deploy whose migrations
+// didn't run. A schema ahead of us (mid rollout) is fine.
+func checkMigrations(ctx context.Context) error {
+	latest, err := latestMigration()
+	if err != nil {
+		return err
+	}
+	applied, err := schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if applied < latest {
+		return fmt.Errorf("%w: schema at %d, expected %d", errMigrationsPending, applied, latest)
+	}
+	return nil
+}
+
+// versionHandler reports the applied schema version and the newest
+// migration this binary ships
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	latest, err := latestMigration()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	applied, err := schemaVersion(r.Context())
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"schema_version":     applied,
+		"latest_migration":   latest,
+		"migrations_pending": applied < latest,
+	})
+}
+
+// migrateHandler applies any pending migrations on demand, for
+// MIGRATE_ON_START=false deployments, and reports what it applied and the
+// resulting version. With nothing pending it is a no-op.
+func migrateHandler(w http.ResponseWriter, r *http.Request) {
+	var applied []migration
+	err := withInitLock(r.Context(), func(*sql.Conn) error {
+		var err error
+		applied, err = runMigrations(r.Context())
+		return err
+	})
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	// Overrides stored before startup (or by another replica) apply now that
+	// the feature_flags table is sure to exist
+	if err := flags.Load(r.Context()); err != nil {
+		log.Println("⚠️ Failed to reload feature flags after migrating:", err)
+	}
+	version, err := schemaVersion(r.Context())
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	names := []string{}
+	for _, m := range applied {
+		names = append(names, m.Name)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"applied":           names,
+		"migration_version": version,
+	})
+}
@@ -0,0 +1,6 @@
+-- IF NOT EXISTS so databases created before migrations existed are adopted as-is
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
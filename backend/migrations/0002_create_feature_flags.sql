@@ -0,0 +1,6 @@
+-- Runtime overrides for feature flags, set via PUT /admin/flags
+CREATE TABLE feature_flags (
+    name VARCHAR(100) PRIMARY KEY,
+    enabled BOOLEAN NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,4 @@
+-- Last change to a user, for Last-Modified / If-Modified-Since. Existing
+-- rows haven't changed since they were created.
+ALTER TABLE users ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+UPDATE users SET updated_at = created_at WHERE created_at IS NOT NULL;
@@ -0,0 +1,4 @@
+-- Full-text index for GET /api/users/search. The 'simple' configuration
+-- lowercases words without stemming, which suits names. Queries must use
+-- the same to_tsvector('simple', name) expression to hit it.
+CREATE INDEX IF NOT EXISTS users_name_fts_idx ON users USING GIN (to_tsvector('simple', name));
@@ -0,0 +1,3 @@
+-- Range scans on created_at: GET /api/users/recent-count, the created_after
+-- and created_before filters of POST /api/users/search, and the extremes.
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at);
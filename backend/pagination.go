@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Page sizes shared by every list endpoint, from PAGE_SIZE_DEFAULT and
+// PAGE_SIZE_MAX, and whether a bigger limit is an error (STRICT_PAGINATION)
+var (
+	pageSizeDefault  = 100
+	pageSizeMax      = 1000
+	strictPagination bool
+)
+
+// parsePagination reads the optional limit and offset query params. A
+// missing limit means pageSizeDefault. One above pageSizeMax is clamped to
+// it, so clients can simply ask for "as many as allowed", unless
+// strictPagination makes it an error. Errors are meant for a 400.
+func parsePagination(r *http.Request) (limit, offset int, err error) {
+	limit = pageSizeDefault
+	q := r.URL.Query()
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, 0, errors.New("limit must be a positive integer")
+		}
+		if limit, err = checkLimit(n); err != nil {
+			return 0, 0, err
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+// checkLimit applies the page size rules to a limit the client asked for,
+// whether it came from the query string or a request body
+func checkLimit(n int) (int, error) {
+	if n <= 0 {
+		return 0, errors.New("limit must be a positive integer")
+	}
+	if strictPagination && n > pageSizeMax {
+		return 0, fmt.Errorf("limit exceeds maximum of %d", pageSizeMax)
+	}
+	return min(n, pageSizeMax), nil
+}
+
+// paginationLinks builds an RFC 5988 Link header pointing at the next and
+// previous pages. prev is left out on the first page and next on the last.
+// u is the request URL with BASE_PATH already stripped, so it is added back.
+func paginationLinks(u *url.URL, limit, offset, total int) string {
+	pageURL := func(offset int) string {
+		q := u.Query()
+		q.Set("limit", strconv.Itoa(limit))
+		q.Set("offset", strconv.Itoa(offset))
+		return (&url.URL{Path: basePath + u.Path, RawQuery: q.Encode()}).String()
+	}
+
+	var links []string
+	if offset+limit < total {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(offset+limit)))
+	}
+	if offset > 0 {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(max(offset-limit, 0))))
+	}
+	return strings.Join(links, ", ")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// usePageSizes sets the page size rules for the duration of the test
+func usePageSizes(t *testing.T, def, max int, strict bool) {
+	t.Helper()
+	prevDefault, prevMax, prevStrict := pageSizeDefault, pageSizeMax, strictPagination
+	pageSizeDefault, pageSizeMax, strictPagination = def, max, strict
+	t.Cleanup(func() { pageSizeDefault, pageSizeMax, strictPagination = prevDefault, prevMax, prevStrict })
+}
+
+// listedUsers GETs path and returns the status and how many users came back
+func listedUsers(t *testing.T, h http.Handler, path string) (int, int) {
+	t.Helper()
+	rec := serve(h, "GET", path, "")
+	var users []User
+	json.Unmarshal(rec.Body.Bytes(), &users)
+	return rec.Code, len(users)
+}
+
+func TestPageSizeDefaultApplied(t *testing.T) {
+	useFakeStore(t, "a", "b", "c", "d", "e")
+	usePageSizes(t, 2, 3, false)
+	h := newTestHandler(testConfig(t))
+
+	if code, n := listedUsers(t, h, "/api/users"); code != http.StatusOK || n != 2 {
+		t.Errorf("no limit: %d with %d users, want 200 with the default 2", code, n)
+	}
+}
+
+func TestPageSizeClampedAtMax(t *testing.T) {
+	useFakeStore(t, "a", "b", "c", "d", "e")
+	usePageSizes(t, 2, 3, false)
+	h := newTestHandler(testConfig(t))
+
+	if code, n := listedUsers(t, h, "/api/users?limit=1000000"); code != http.StatusOK || n != 3 {
+		t.Errorf("limit=1000000: %d with %d users, want 200 clamped to 3", code, n)
+	}
+	rec := serve(h, "GET", "/api/users?limit=1000000", "")
+	if link := rec.Header().Get("Link"); link == "" {
+		t.Error("clamped page has no Link header")
+	}
+}
+
+func TestStrictPaginationRejectsOversizedLimits(t *testing.T) {
+	useFakeStore(t, "a", "b", "c", "d", "e")
+	usePageSizes(t, 2, 3, true)
+	h := newTestHandler(testConfig(t))
+
+	if code, _ := listedUsers(t, h, "/api/users?limit=4"); code != http.StatusBadRequest {
+		t.Errorf("limit=4: status = %d, want 400", code)
+	}
+	if code, n := listedUsers(t, h, "/api/users?limit=3"); code != http.StatusOK || n != 3 {
+		t.Errorf("limit=3: %d with %d users, want 200 with 3", code, n)
+	}
+}
+
+func TestInvalidPagination(t *testing.T) {
+	useFakeStore(t)
+	h := newTestHandler(testConfig(t))
+	for _, q := range []string{"limit=0", "limit=-1", "limit=ten", "offset=-1", "offset=x"} {
+		if code, _ := listedUsers(t, h, "/api/users?"+q); code != http.StatusBadRequest {
+			t.Errorf("?%s: status = %d, want 400", q, code)
+		}
+	}
+}
+
+func TestPageSizeConfig(t *testing.T) {
+	cfg := testConfig(t)
+	if cfg.PageSizeDefault != 100 || cfg.PageSizeMax != 1000 || cfg.StrictPagination {
+		t.Errorf("defaults = %d, %d, strict %t", cfg.PageSizeDefault, cfg.PageSizeMax, cfg.StrictPagination)
+	}
+	if err := configError(t, "PAGE_SIZE_DEFAULT", "50", "PAGE_SIZE_MAX", "10"); err == nil {
+		t.Error("PAGE_SIZE_DEFAULT above PAGE_SIZE_MAX: no error")
+	}
+}
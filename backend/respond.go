@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// dbRetryAfter is the Retry-After (seconds) we suggest while the DB is
+// unreachable or overloaded
+const dbRetryAfter = "5"
+
+// prettyJSON is the PRETTY_JSON default for indenting responses; ?pretty=
+// overrides it per request
+var prettyJSON bool
+
+// prettyWriter marks a response whose JSON should be indented
+type prettyWriter struct {
+	http.ResponseWriter
+}
+
+func (w prettyWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// negotiatePrettyJSON picks indented or compact JSON for the request from
+// ?pretty=true|false, falling back to prettyJSON. writeJSON reads the choice
+// off w, so this must wrap the handlers inside limitDuration.
+func negotiatePrettyJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pretty, err := strconv.ParseBool(r.URL.Query().Get("pretty"))
+		if err != nil {
+			pretty = prettyJSON
+		}
+		if pretty {
+			w = prettyWriter{w}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// envelopeResponses wraps successful responses in an envelope
+// (ENVELOPE_RESPONSES); errors keep their usual {"error": ...} shape
+var envelopeResponses bool
+
+// envelope is a successful response in ENVELOPE_RESPONSES mode
+type envelope struct {
+	Data interface{}  `json:"data"`
+	Meta envelopeMeta `json:"meta"`
+}
+
+type envelopeMeta struct {
+	RequestID string    `json:"request_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// writeJSON sends v as a JSON response with the given status code, indented
+// when the client asked for pretty output and enveloped for a 2xx in
+// ENVELOPE_RESPONSES mode. The body is encoded up front so Content-Length
+// is always set, which also gives HEAD requests (routed to our GET handlers
+// by the mux) the same headers as GET, and so a value that fails to encode
+// becomes a 500 instead of a broken body.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	if envelopeResponses && status >= 200 && status < 300 {
+		v = envelope{Data: v, Meta: envelopeMeta{RequestID: w.Header().Get(requestIDHeader), Timestamp: time.Now().UTC()}}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if _, ok := w.(prettyWriter); ok {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		log.Printf("❌ Failed to encode response (request %s): %v\n", w.Header().Get(requestIDHeader), err)
+		writeError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// notModifiedSince sets Last-Modified to modified and, when the request's
+// If-Modified-Since is no older, answers 304 and reports true. HTTP dates
+// have one-second resolution, so modified is truncated to match.
+func notModifiedSince(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	modified = modified.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagListed reports whether etag appears in an If-Match style header, a
+// comma-separated list of entity tags. Weak tags never match.
+func etagListed(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeError sends a {"error": message} response, translated into the
+// client's language when we have a translation
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": localize(w, message)})
+}
+
+// writeDBError logs a failed query and answers with a generic error, so
+// driver messages and connection details never reach the client
+func writeDBError(w http.ResponseWriter, err error) {
+	dbErrors.Add(1)
+	log.Printf("❌ Database error (request %s): %v\n", w.Header().Get(requestIDHeader), err)
+	checkCredentials(err)
+
+	switch {
+	case errors.Is(err, errAcquireTimeout):
+		w.Header().Set("Retry-After", dbRetryAfter)
+		writeError(w, http.StatusServiceUnavailable, "database busy: no connection available")
+		return
+	case isBreakerOpen(err):
+		w.Header().Set("Retry-After", breakerRetryAfter())
+		writeError(w, http.StatusServiceUnavailable, "database temporarily unavailable")
+		return
+	case isConnectionError(err):
+		w.Header().Set("Retry-After", dbRetryAfter)
+		writeError(w, http.StatusServiceUnavailable, "database temporarily unavailable")
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		w.Header().Set("Retry-After", dbRetryAfter)
+		writeError(w, http.StatusServiceUnavailable, "database query timed out")
+		return
+	}
+	writeError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// isConnectionError reports whether err means we couldn't talk to Postgres
+// at all, as opposed to a query that Postgres rejected
+func isConnectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	// 👇 context.DeadlineExceeded satisfies net.Error too, but a query that
+	// ran out of time did reach the database
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case strings.HasPrefix(string(pqErr.Code), "08"): // connection_exception
+			return true
+		case pqErr.Code == "57P01", pqErr.Code == "57P02", pqErr.Code == "57P03": // shutting down / starting up
+			return true
+		case pqErr.Code == "53300": // too_many_connections
+			return true
+		}
+	}
+	return false
+}
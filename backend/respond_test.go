@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// indented reports whether a JSON body spans several lines; compact bodies
+// only end in the encoder's newline
+func indented(body string) bool {
+	return strings.Contains(strings.TrimSuffix(body, "\n"), "\n")
+}
+
+func TestPrettyJSON(t *testing.T) {
+	useFakeStore(t, "Ada")
+	h := newTestHandler(testConfig(t))
+
+	for path, want := range map[string]bool{
+		"/api/users":              false,
+		"/api/users?pretty=false": false,
+		"/api/users?pretty=true":  true,
+		"/api/users/1?pretty=1":   true,
+		"/api/users/99?pretty=1":  true, // errors too
+		"/api/users?pretty=maybe": false,
+	} {
+		rec := serve(h, "GET", path, "")
+		if got := indented(rec.Body.String()); got != want {
+			t.Errorf("GET %s: indented = %v, want %v: %q", path, got, want, rec.Body)
+		}
+	}
+}
+
+func TestPrettyJSONDefault(t *testing.T) {
+	useFakeStore(t, "Ada")
+	prev := prettyJSON
+	prettyJSON = true
+	t.Cleanup(func() { prettyJSON = prev })
+	h := newTestHandler(testConfig(t))
+
+	if rec := serve(h, "GET", "/api/users", ""); !indented(rec.Body.String()) || rec.Code != http.StatusOK {
+		t.Errorf("PRETTY_JSON on: got %d %q, want indented", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "GET", "/api/users?pretty=false", ""); indented(rec.Body.String()) {
+		t.Errorf("?pretty=false did not override PRETTY_JSON: %q", rec.Body)
+	}
+}
+
+func TestEnvelopeResponses(t *testing.T) {
+	useFakeStore(t, "Ada", "Grace")
+	h := newTestHandler(testConfig(t))
+	bare := map[string]string{}
+	for _, path := range []string{"/api/users", "/api/users/1", "/api/users/99"} {
+		bare[path] = serve(h, "GET", path, "").Body.String()
+	}
+
+	prev := envelopeResponses
+	envelopeResponses = true
+	t.Cleanup(func() { envelopeResponses = prev })
+
+	for _, path := range []string{"/api/users", "/api/users/1"} {
+		rec := serve(h, "GET", path, "")
+		var resp struct {
+			Data json.RawMessage `json:"data"`
+			Meta struct {
+				RequestID string    `json:"request_id"`
+				Timestamp time.Time `json:"timestamp"`
+			} `json:"meta"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		if string(resp.Data)+"\n" != bare[path] {
+			t.Errorf("GET %s: data = %s, want the bare body %s", path, resp.Data, bare[path])
+		}
+		if resp.Meta.RequestID == "" || resp.Meta.RequestID != rec.Header().Get(requestIDHeader) {
+			t.Errorf("GET %s: meta.request_id = %q, header %q", path, resp.Meta.RequestID, rec.Header().Get(requestIDHeader))
+		}
+		if time.Since(resp.Meta.Timestamp) > time.Minute {
+			t.Errorf("GET %s: meta.timestamp = %s", path, resp.Meta.Timestamp)
+		}
+	}
+
+	// Errors keep their bare shape
+	if got := serve(h, "GET", "/api/users/99", "").Body.String(); got != bare["/api/users/99"] {
+		t.Errorf("404 = %s, want %s", got, bare["/api/users/99"])
+	}
+}
+
+func TestEnvelopeResponsesConfig(t *testing.T) {
+	if cfg := testConfig(t); cfg.EnvelopeResponses {
+		t.Error("ENVELOPE_RESPONSES is on by default")
+	}
+	if cfg := testConfig(t, "ENVELOPE_RESPONSES", "true"); !cfg.EnvelopeResponses {
+		t.Error("ENVELOPE_RESPONSES=true was ignored")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// routeInfo describes one registered route for GET /api/routes
+type routeInfo struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Description string `json:"description"`
+}
+
+// router registers handlers on a mux and remembers what it registered, so
+// /api/routes can never drift from what is actually served
+type router struct {
+	mux    *http.ServeMux
+	routes []routeInfo
+}
+
+func newRouter() *router {
+	return &router{mux: http.NewServeMux()}
+}
+
+// Handle registers h for a "METHOD /path" pattern
+func (rt *router) Handle(pattern, description string, h http.Handler) {
+	rt.mux.Handle(pattern, h)
+	method, path, _ := strings.Cut(pattern, " ")
+	rt.routes = append(rt.routes, routeInfo{Method: method, Path: basePath + path, Description: description})
+}
+
+// HandleFunc registers a handler function for a "METHOD /path" pattern
+func (rt *router) HandleFunc(pattern, description string, h http.HandlerFunc) {
+	rt.Handle(pattern, description, h)
+}
+
+// pattern returns the pattern of the route r will be served by ("" if none)
+func (rt *router) pattern(r *http.Request) string {
+	_, pattern := rt.mux.Handler(r)
+	return pattern
+}
+
+// registered reports whether pattern was registered
+func (rt *router) registered(pattern string) bool {
+	method, path, _ := strings.Cut(pattern, " ")
+	for _, route := range rt.routes {
+		if route.Method == method && route.Path == basePath+path {
+			return true
+		}
+	}
+	return false
+}
+
+// routesHandler lists every registered route in registration order
+func (rt *router) routesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, rt.routes)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// Column describes one column of a database table
+type Column struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// schemaHandler describes the columns of the users table
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	columns, err := usersColumns(r.Context())
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"table":   "users",
+		"columns": columns,
+	})
+}
+
+// usersColumns reads the users table definition from information_schema
+func usersColumns(ctx context.Context) ([]Column, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT column_name, data_type, is_nullable = 'YES'
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'users'
+		ORDER BY ordinal_position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := []Column{}
+	for rows.Next() {
+		var c Column
+		if err := rows.Scan(&c.Name, &c.Type, &c.Nullable); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
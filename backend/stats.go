@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// inFlight is the number of requests currently being handled
+var inFlight atomic.Int64
+
+// startedAt is when the process started, for the uptime in /stats
+var startedAt = time.Now()
+
+// statsHandler is a lightweight load snapshot for when Prometheus isn't handy
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"in_flight":       inFlight.Load(),
+		"requests_served": requestsServed.Value(),
+		"uptime_seconds":  int64(time.Since(startedAt).Seconds()),
+		"goroutines":      runtime.NumGoroutine(),
+	})
+}
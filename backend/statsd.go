@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// statsdMaxPacket keeps each UDP packet under a typical MTU; metrics are
+// batched into as few packets as fit
+const statsdMaxPacket = 1432
+
+// statsdFlusher pushes our counters and gauges to a StatsD (or Datadog)
+// agent, for setups that don't scrape /metrics. Counters are sent as the
+// change since the previous flush.
+type statsdFlusher struct {
+	conn   net.Conn
+	prefix string
+	db     *sql.DB
+
+	// totals at the previous flush
+	lastRequests, lastDBErrors, lastPoolWaits int64
+}
+
+// newStatsdFlusher sends to addr (host:port). UDP is connectionless, so an
+// agent that isn't there yet only loses metrics.
+func newStatsdFlusher(addr, prefix string, db *sql.DB) (*statsdFlusher, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdFlusher{conn: conn, prefix: prefix, db: db}, nil
+}
+
+// flush sends one round of metrics; it runs every STATSD_INTERVAL
+func (f *statsdFlusher) flush(ctx context.Context) {
+	var lines []string
+	counter := func(name string, total int64, last *int64) {
+		lines = append(lines, fmt.Sprintf("%s%s:%d|c", f.prefix, name, total-*last))
+		*last = total
+	}
+	gauge := func(name string, value float64) {
+		lines = append(lines, fmt.Sprintf("%s%s:%g|g", f.prefix, name, value))
+	}
+
+	counter("requests", requestsServed.Value(), &f.lastRequests)
+	counter("db_errors", dbErrors.Value(), &f.lastDBErrors)
+	gauge("in_flight", float64(inFlight.Load()))
+	gauge("users_total", gaugeValue(usersTotal))
+
+	pool := f.db.Stats()
+	gauge("db.open_connections", float64(pool.OpenConnections))
+	gauge("db.in_use", float64(pool.InUse))
+	gauge("db.idle", float64(pool.Idle))
+	counter("db.wait_count", pool.WaitCount, &f.lastPoolWaits)
+
+	if err := f.send(lines); err != nil {
+		log.Println("⚠️ Failed to send StatsD metrics:", err)
+	}
+}
+
+// send writes lines newline-separated, starting a new packet whenever the
+// next line wouldn't fit
+func (f *statsdFlusher) send(lines []string) error {
+	var packet bytes.Buffer
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			if _, err := f.conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() == 0 {
+		return nil
+	}
+	_, err := f.conn.Write(packet.Bytes())
+	return err
+}
+
+// gaugeValue reads the current value of a Prometheus gauge
+func gaugeValue(g prometheus.Gauge) float64 {
+	var m dto.Metric
+	if err := g.Write(&m); err != nil {
+		return 0
+	}
+	return m.GetGauge().GetValue()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeStatsd listens for StatsD packets on a free local UDP port
+func fakeStatsd(t *testing.T) (addr string, packets func() []string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn.LocalAddr().String(), func() []string {
+		var got []string
+		buf := make([]byte, 64*1024)
+		for {
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return got
+			}
+			got = append(got, string(buf[:n]))
+		}
+	}
+}
+
+func TestStatsdFlush(t *testing.T) {
+	addr, packets := fakeStatsd(t)
+	pool := sql.OpenDB(stubRows{})
+	defer pool.Close()
+	f, err := newStatsdFlusher(addr, "backend.", pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.flush(context.Background())
+	got := packets()
+	if len(got) != 1 {
+		t.Fatalf("got %d packets, want one batch: %q", len(got), got)
+	}
+	var names []string
+	for _, line := range strings.Split(got[0], "\n") {
+		name, _, _ := strings.Cut(line, ":")
+		names = append(names, name)
+	}
+	for _, want := range []string{
+		"backend.requests", "backend.db_errors", "backend.in_flight", "backend.users_total",
+		"backend.db.open_connections", "backend.db.in_use", "backend.db.idle", "backend.db.wait_count",
+	} {
+		if !slices.Contains(names, want) {
+			t.Errorf("no %s in %q", want, got[0])
+		}
+	}
+
+	// Counters report the change since the last flush
+	requestsServed.Add(3)
+	f.flush(context.Background())
+	if got := packets(); len(got) != 1 || !strings.Contains(got[0], "backend.requests:3|c\n") {
+		t.Errorf("second flush = %q, want backend.requests:3|c", got)
+	}
+}
+
+func TestStatsdBatchesIntoPackets(t *testing.T) {
+	addr, packets := fakeStatsd(t)
+	f, err := newStatsdFlusher(addr, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := strings.Repeat("x", 100) + ":1|c"
+	lines := make([]string, 30) // ~3KB, more than one packet's worth
+	for i := range lines {
+		lines[i] = line
+	}
+	if err := f.send(lines); err != nil {
+		t.Fatal(err)
+	}
+	got := packets()
+	if len(got) != 3 {
+		t.Errorf("got %d packets, want 3", len(got))
+	}
+	sent := 0
+	for _, p := range got {
+		if len(p) > statsdMaxPacket {
+			t.Errorf("%d byte packet, over the %d limit", len(p), statsdMaxPacket)
+		}
+		sent += len(strings.Split(p, "\n"))
+	}
+	if sent != len(lines) {
+		t.Errorf("%d lines arrived, want %d", sent, len(lines))
+	}
+}
+
+func TestStatsdOffByDefault(t *testing.T) {
+	if cfg := testConfig(t); cfg.StatsdAddr != "" {
+		t.Errorf("StatsdAddr = %q, want StatsD off unless STATSD_ADDR is set", cfg.StatsdAddr)
+	}
+}
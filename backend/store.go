@@ -0,0 +1,716 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrNotFound is returned by a UserStore when no user has the given ID
+var ErrNotFound = errors.New("user not found")
+
+// ErrAmbiguous is returned when a lookup that should find one user finds several
+var ErrAmbiguous = errors.New("multiple users match")
+
+// ErrModified is returned by a conditional update when the user changed
+// since the version the caller read
+var ErrModified = errors.New("user was modified")
+
+// ErrDuplicate is returned when a write would break a unique constraint
+var ErrDuplicate = errors.New("user already exists")
+
+// ErrValidation is returned when Postgres rejects a write's values, e.g. a
+// NOT NULL or CHECK constraint the handler's own validation didn't catch
+var ErrValidation = errors.New("invalid user data")
+
+// dbError is a Postgres error classified as ErrDuplicate or ErrValidation.
+// Its message is the kind's, so driver details never reach clients, while
+// errors.As still finds the *pq.Error for metrics.
+type dbError struct {
+	kind error
+	err  error
+}
+
+func (e *dbError) Error() string   { return e.kind.Error() }
+func (e *dbError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// classifyConstraint wraps an integrity constraint violation in the
+// matching domain error and returns anything else unchanged, so handlers
+// never look at Postgres error codes
+func classifyConstraint(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code.Class() != "23" { // integrity_constraint_violation
+		return err
+	}
+	if pqErr.Code == "23505" { // unique_violation
+		return &dbError{kind: ErrDuplicate, err: err}
+	}
+	return &dbError{kind: ErrValidation, err: err}
+}
+
+// errRolledBack marks batch items that succeeded (or never ran) but were
+// undone because another item in the same all-or-nothing batch failed
+var errRolledBack = errors.New("not applied: batch rolled back")
+
+// Rename is one item of a batch rename
+type Rename struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// RenameResult is the outcome of one Rename: the updated user, or why it failed
+type RenameResult struct {
+	User User
+	Err  error
+}
+
+// ListOptions narrows a List call; zero values mean "all users"
+type ListOptions struct {
+	Limit  int // 0 = no limit
+	Offset int
+	Sort   []SortField // empty = by id
+	Fields []string    // columns to select, from selectColumns; empty = all
+}
+
+// UserFilter is the criteria of a Filter call; zero values don't filter
+type UserFilter struct {
+	NameContains  string    // case-insensitive substring of the name
+	CreatedAfter  time.Time // inclusive
+	CreatedBefore time.Time // exclusive
+	Sort          []SortField
+	Limit         int
+	Offset        int
+}
+
+// SortField is one ORDER BY key. Column must come from sortColumns.
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// sortColumns are the columns clients may sort users by
+var sortColumns = map[string]bool{"id": true, "name": true, "created_at": true, "updated_at": true}
+
+// orderBy builds the ORDER BY clause for fields, ending with id so pages
+// stay stable when earlier keys tie. Columns are checked against sortColumns
+// before they reach the SQL.
+func orderBy(fields []SortField) (string, error) {
+	var keys []string
+	sawID := false
+	for _, f := range fields {
+		if !sortColumns[f.Column] {
+			return "", fmt.Errorf("invalid sort field %q", f.Column)
+		}
+		key := f.Column
+		if f.Desc {
+			key += " DESC"
+		}
+		keys = append(keys, key)
+		sawID = sawID || f.Column == "id"
+	}
+	if !sawID {
+		keys = append(keys, "id")
+	}
+	return " ORDER BY " + strings.Join(keys, ", "), nil
+}
+
+// UserStore is everything the handlers need from the users table. Handlers
+// only talk to the global store, so tests can swap in a fake.
+type UserStore interface {
+	List(ctx context.Context, opts ListOptions) ([]User, error)
+	Count(ctx context.Context) (int, error)
+	// CountSince counts the users created within window of the database's NOW()
+	CountSince(ctx context.Context, window time.Duration) (int, error)
+	Get(ctx context.Context, id int) (User, error)
+	// GetPrimary is Get that never reads from the replica, for checks a
+	// write is about to depend on
+	GetPrimary(ctx context.Context, id int) (User, error)
+	// GetByName finds the user whose name matches case-insensitively
+	GetByName(ctx context.Context, name string) (User, error)
+	Create(ctx context.Context, name string) (User, error)
+	Update(ctx context.Context, id int, name string) (User, error)
+	// UpdateIfUnmodified renames the user only if its updated_at is still
+	// updatedAt, and otherwise returns ErrModified
+	UpdateIfUnmodified(ctx context.Context, id int, name string, updatedAt time.Time) (User, error)
+	Delete(ctx context.Context, id int) error
+	// RenameAll gives every user in ids the same name in one statement and
+	// reports how many were updated (ids that don't exist are skipped)
+	RenameAll(ctx context.Context, ids []int, name string) (int64, error)
+	// UpsertByName creates the user named name, or renames the one whose
+	// name matches case-insensitively to this spelling, reporting whether it
+	// was created. Several matches are ErrAmbiguous.
+	UpsertByName(ctx context.Context, name string) (u User, created bool, err error)
+	// SetCreatedAt backdates a user (test environments only)
+	SetCreatedAt(ctx context.Context, id int, createdAt time.Time) (User, error)
+
+	// RenameMany applies renames in one transaction. Missing users and
+	// constraint violations are reported per item: by default they roll the
+	// whole batch back, with partial only the failing items are skipped.
+	RenameMany(ctx context.Context, renames []Rename, partial bool) ([]RenameResult, error)
+
+	// Search finds users whose name matches the words in query, best
+	// matches first
+	Search(ctx context.Context, query string, limit, offset int) ([]User, error)
+
+	// Filter returns a page of the users matching every criterion in f,
+	// along with how many match in total
+	Filter(ctx context.Context, f UserFilter) (users []User, total int, err error)
+
+	// Extremes returns the oldest and newest users (nil when there are none)
+	Extremes(ctx context.Context) (oldest, newest *User, err error)
+}
+
+var store UserStore
+
+// postgresStore is the UserStore backed by Postgres
+type postgresStore struct {
+	db *sql.DB
+
+	// replica, when set, serves every read; writes always use db
+	replica *sql.DB
+
+	// retryReads retries a read once when its connection dropped (e.g.
+	// during a managed-Postgres failover)
+	retryReads bool
+
+	// tagQueries prefixes every query with a /* op=... */ comment naming
+	// the store operation, so pg_stat_activity shows who issued it
+	tagQueries bool
+
+	// acquireTimeout bounds the wait for a pooled connection and
+	// queryTimeout the query itself (0 = only the request's deadline)
+	acquireTimeout time.Duration
+	queryTimeout   time.Duration
+}
+
+// errAcquireTimeout means the pool had no free connection in time, as
+// opposed to a query that ran too long
+var errAcquireTimeout = errors.New("timed out waiting for a database connection")
+
+// read runs a read-only operation, retrying it once on a fresh pooled
+// connection if it failed with a connection error and retries are enabled.
+// fn must be safe to run twice. Writes never go through read: we can't know
+// whether a failed write was applied.
+func (s *postgresStore) read(fn func() error) error {
+	err := fn()
+	if err != nil && s.retryReads && isConnectionError(err) && !isBreakerOpen(err) {
+		log.Println("🔁 Retrying read after connection error:", err)
+		err = fn()
+	}
+	return err
+}
+
+// reader returns the store to run reads on: a copy pointed at the replica
+// when one is configured, otherwise s itself
+func (s *postgresStore) reader() *postgresStore {
+	if s.replica == nil {
+		return s
+	}
+	replica := *s
+	replica.db, replica.replica = s.replica, nil
+	return &replica
+}
+
+// withConn takes a connection from the pool, waiting at most acquireTimeout,
+// and runs fn on it under queryTimeout. The wait is recorded in
+// db_conn_acquire_seconds, so a saturated pool shows up before it times out.
+func (s *postgresStore) withConn(ctx context.Context, fn func(ctx context.Context, conn *sql.Conn) error) error {
+	acquireCtx := ctx
+	if s.acquireTimeout > 0 {
+		var cancel context.CancelFunc
+		acquireCtx, cancel = context.WithTimeout(ctx, s.acquireTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	conn, err := s.db.Conn(acquireCtx)
+	dbConnAcquire.Observe(time.Since(start).Seconds())
+	if err != nil {
+		// 👇 Only our own acquire deadline counts; a cancelled request isn't
+		// the pool's fault
+		if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %w", errAcquireTimeout, err)
+		}
+		return err
+	}
+	defer conn.Close()
+
+	if s.queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.queryTimeout)
+		defer cancel()
+	}
+	return fn(ctx, conn)
+}
+
+// tag prefixes query with its op comment when tagQueries is on. op is
+// always one of our own constant names, never client input.
+func (s *postgresStore) tag(op, query string) string {
+	if !s.tagQueries {
+		return query
+	}
+	return "/* op=" + op + " */ " + query
+}
+
+// queryRow runs a single-row query and scans it into dest. Every store
+// query goes through queryRow, query or exec, which run it through the
+// circuit breaker, record failures by op and classify constraint violations.
+func (s *postgresStore) queryRow(ctx context.Context, op, query string, args []interface{}, dest ...interface{}) error {
+	err := guard(func() error {
+		return s.withConn(ctx, func(ctx context.Context, conn *sql.Conn) error {
+			return conn.QueryRowContext(ctx, s.tag(op, query), args...).Scan(dest...)
+		})
+	})
+	return classifyConstraint(observeQuery(op, err))
+}
+
+// query runs a multi-row query, calling each for every row
+func (s *postgresStore) query(ctx context.Context, op, query string, args []interface{}, each func(*sql.Rows) error) error {
+	err := guard(func() error {
+		return s.withConn(ctx, func(ctx context.Context, conn *sql.Conn) error {
+			rows, err := conn.QueryContext(ctx, s.tag(op, query), args...)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+				if err := each(rows); err != nil {
+					return err
+				}
+			}
+			return rows.Err()
+		})
+	})
+	return classifyConstraint(observeQuery(op, err))
+}
+
+// exec runs a statement that returns no rows
+func (s *postgresStore) exec(ctx context.Context, op, query string, args ...interface{}) (sql.Result, error) {
+	var res sql.Result
+	err := guard(func() error {
+		return s.withConn(ctx, func(ctx context.Context, conn *sql.Conn) (err error) {
+			res, err = conn.ExecContext(ctx, s.tag(op, query), args...)
+			return err
+		})
+	})
+	return res, classifyConstraint(observeQuery(op, err))
+}
+
+// userColumns is the column list scanned by scanUser
+const userColumns = "id, name, created_at, updated_at"
+
+// userFields are scan destinations matching userColumns
+func userFields(u *User) []interface{} {
+	return []interface{}{&u.ID, &u.Name, &u.CreatedAt, &u.UpdatedAt}
+}
+
+// selectColumns are the columns a List may be narrowed to, with the User
+// field each one scans into
+var selectColumns = map[string]func(u *User) interface{}{
+	"id":         func(u *User) interface{} { return &u.ID },
+	"name":       func(u *User) interface{} { return &u.Name },
+	"created_at": func(u *User) interface{} { return &u.CreatedAt },
+	"updated_at": func(u *User) interface{} { return &u.UpdatedAt },
+}
+
+// scanUser reads a row selected with userColumns
+func scanUser(rows *sql.Rows, u *User) error {
+	return rows.Scan(userFields(u)...)
+}
+
+// notFound maps "no rows" to ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *postgresStore) List(ctx context.Context, opts ListOptions) ([]User, error) {
+	order, err := orderBy(opts.Sort)
+	if err != nil {
+		return nil, err
+	}
+	columns, scan := userColumns, scanUser
+	if len(opts.Fields) > 0 {
+		for _, f := range opts.Fields {
+			if selectColumns[f] == nil {
+				return nil, fmt.Errorf("invalid field %q", f)
+			}
+		}
+		columns = strings.Join(opts.Fields, ", ")
+		scan = func(rows *sql.Rows, u *User) error {
+			dest := make([]interface{}, len(opts.Fields))
+			for i, f := range opts.Fields {
+				dest[i] = selectColumns[f](u)
+			}
+			return rows.Scan(dest...)
+		}
+	}
+	query := "SELECT " + columns + " FROM users" + order
+	args := []interface{}{}
+	if opts.Limit > 0 {
+		args = append(args, opts.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if opts.Offset > 0 {
+		args = append(args, opts.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	// Collect all users
+	// 👇 Never nil, so an empty page encodes as [] rather than null
+	users := []User{}
+	err = s.read(func() error {
+		users = users[:0]
+		return s.reader().query(ctx, "list", query, args, func(rows *sql.Rows) error {
+			var u User
+			if err := scan(rows, &u); err != nil {
+				return err
+			}
+			users = append(users, u)
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (s *postgresStore) Count(ctx context.Context) (int, error) {
+	var n int
+	err := s.read(func() error {
+		return s.reader().queryRow(ctx, "count", "SELECT COUNT(*) FROM users", nil, &n)
+	})
+	return n, err
+}
+
+func (s *postgresStore) CountSince(ctx context.Context, window time.Duration) (int, error) {
+	var n int
+	err := s.read(func() error {
+		return s.reader().queryRow(ctx, "count_since",
+			"SELECT COUNT(*) FROM users WHERE created_at >= NOW() - $1 * INTERVAL '1 microsecond'",
+			[]interface{}{window.Microseconds()}, &n)
+	})
+	return n, err
+}
+
+func (s *postgresStore) Get(ctx context.Context, id int) (User, error) {
+	var u User
+	err := s.read(func() error {
+		return s.reader().queryRow(ctx, "get", "SELECT "+userColumns+" FROM users WHERE id = $1", []interface{}{id}, userFields(&u)...)
+	})
+	return u, notFound(err)
+}
+
+func (s *postgresStore) GetPrimary(ctx context.Context, id int) (User, error) {
+	var u User
+	err := s.read(func() error {
+		return s.queryRow(ctx, "get_primary", "SELECT "+userColumns+" FROM users WHERE id = $1", []interface{}{id}, userFields(&u)...)
+	})
+	return u, notFound(err)
+}
+
+func (s *postgresStore) GetByName(ctx context.Context, name string) (User, error) {
+	// Names aren't unique, so fetch two rows to detect an ambiguous match
+	var matches []User
+	err := s.read(func() error {
+		matches = nil
+		return s.reader().query(ctx, "get_by_name",
+			"SELECT "+userColumns+" FROM users WHERE lower(name) = lower($1) ORDER BY id LIMIT 2", []interface{}{name},
+			func(rows *sql.Rows) error {
+				var u User
+				if err := scanUser(rows, &u); err != nil {
+					return err
+				}
+				matches = append(matches, u)
+				return nil
+			})
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	switch len(matches) {
+	case 0:
+		return User{}, ErrNotFound
+	case 1:
+		return matches[0], nil
+	default:
+		return User{}, ErrAmbiguous
+	}
+}
+
+func (s *postgresStore) Create(ctx context.Context, name string) (User, error) {
+	var u User
+	err := s.queryRow(ctx, "create", "INSERT INTO users (name) VALUES ($1) RETURNING "+userColumns, []interface{}{name}, userFields(&u)...)
+	return u, err
+}
+
+func (s *postgresStore) Update(ctx context.Context, id int, name string) (User, error) {
+	var u User
+	err := s.queryRow(ctx, "update", "UPDATE users SET name = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1 RETURNING "+userColumns, []interface{}{id, name}, userFields(&u)...)
+	return u, notFound(err)
+}
+
+func (s *postgresStore) UpdateIfUnmodified(ctx context.Context, id int, name string, updatedAt time.Time) (User, error) {
+	var u User
+	err := s.queryRow(ctx, "update_if_unmodified",
+		"UPDATE users SET name = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND updated_at = $3 RETURNING "+userColumns,
+		[]interface{}{id, name, updatedAt}, userFields(&u)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrModified
+	}
+	return u, err
+}
+
+func (s *postgresStore) RenameAll(ctx context.Context, ids []int, name string) (int64, error) {
+	res, err := s.exec(ctx, "rename_all",
+		"UPDATE users SET name = $2, updated_at = CURRENT_TIMESTAMP WHERE id = ANY($1)", pq.Array(ids), name)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *postgresStore) UpsertByName(ctx context.Context, name string) (User, bool, error) {
+	var u User
+	created := false
+	err := guard(func() error {
+		return s.withConn(ctx, func(ctx context.Context, conn *sql.Conn) error {
+			tx, err := conn.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			// 👇 Names aren't unique, so there's no constraint for ON CONFLICT
+			// to use. A transaction-scoped advisory lock on the lowercased
+			// name keeps two upserts of the same name from both inserting.
+			if _, err := tx.ExecContext(ctx, s.tag("upsert_by_name", "SELECT pg_advisory_xact_lock(hashtext(lower($1)))"), name); err != nil {
+				return err
+			}
+
+			var ids []int
+			rows, err := tx.QueryContext(ctx, s.tag("upsert_by_name", "SELECT id FROM users WHERE lower(name) = lower($1) ORDER BY id LIMIT 2 FOR UPDATE"), name)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				var id int
+				if err := rows.Scan(&id); err != nil {
+					rows.Close()
+					return err
+				}
+				ids = append(ids, id)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			switch len(ids) {
+			case 0:
+				created = true
+				err = tx.QueryRowContext(ctx, s.tag("upsert_by_name", "INSERT INTO users (name) VALUES ($1) RETURNING "+userColumns), name).Scan(userFields(&u)...)
+			case 1:
+				err = tx.QueryRowContext(ctx,
+					s.tag("upsert_by_name", "UPDATE users SET name = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1 RETURNING "+userColumns), ids[0], name).Scan(userFields(&u)...)
+			default:
+				return ErrAmbiguous
+			}
+			if err != nil {
+				return err
+			}
+			return tx.Commit()
+		})
+	})
+	if errors.Is(err, ErrAmbiguous) {
+		return User{}, false, err
+	}
+	return u, created, classifyConstraint(observeQuery("upsert_by_name", err))
+}
+
+func (s *postgresStore) SetCreatedAt(ctx context.Context, id int, createdAt time.Time) (User, error) {
+	var u User
+	err := s.queryRow(ctx, "set_created_at",
+		"UPDATE users SET created_at = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1 RETURNING "+userColumns,
+		[]interface{}{id, createdAt}, userFields(&u)...)
+	return u, notFound(err)
+}
+
+func (s *postgresStore) Delete(ctx context.Context, id int) error {
+	res, err := s.exec(ctx, "delete", "DELETE FROM users WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) RenameMany(ctx context.Context, renames []Rename, partial bool) ([]RenameResult, error) {
+	results := make([]RenameResult, len(renames))
+	err := guard(func() error {
+		return s.withConn(ctx, func(ctx context.Context, conn *sql.Conn) error {
+			tx, err := conn.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			failed := false
+			for i, rn := range renames {
+				if failed {
+					results[i].Err = errRolledBack
+					continue
+				}
+				// 👇 A failed statement aborts the whole transaction in Postgres,
+				// so partial mode gives every item its own savepoint
+				if partial {
+					if _, err := tx.ExecContext(ctx, "SAVEPOINT rename_item"); err != nil {
+						return err
+					}
+				}
+
+				var u User
+				err := classifyConstraint(notFound(tx.QueryRowContext(ctx,
+					s.tag("rename_many", "UPDATE users SET name = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1 RETURNING "+userColumns), rn.ID, rn.Name).Scan(userFields(&u)...)))
+				switch {
+				case err == nil:
+					results[i].User = u
+				case errors.Is(err, ErrNotFound) || errors.Is(err, ErrDuplicate) || errors.Is(err, ErrValidation):
+					results[i].Err = err
+				default:
+					return err
+				}
+
+				if !partial {
+					failed = err != nil
+					continue
+				}
+				release := "RELEASE SAVEPOINT rename_item"
+				if err != nil {
+					release = "ROLLBACK TO SAVEPOINT rename_item"
+				}
+				if _, err := tx.ExecContext(ctx, release); err != nil {
+					return err
+				}
+			}
+
+			if failed {
+				for i := range results {
+					if results[i].Err == nil {
+						results[i].Err = errRolledBack
+					}
+				}
+				return nil // the deferred Rollback undoes the batch
+			}
+			return tx.Commit()
+		})
+	})
+	return results, observeQuery("rename_many", err)
+}
+
+func (s *postgresStore) Search(ctx context.Context, query string, limit, offset int) ([]User, error) {
+	users := []User{}
+	err := s.read(func() error {
+		users = users[:0]
+		return s.reader().query(ctx, "search", `
+			SELECT `+userColumns+` FROM users, plainto_tsquery('simple', $1) AS q
+			WHERE to_tsvector('simple', name) @@ q
+			ORDER BY ts_rank(to_tsvector('simple', name), q) DESC, id
+			LIMIT $2 OFFSET $3`, []interface{}{query, limit, offset},
+			func(rows *sql.Rows) error {
+				var u User
+				if err := scanUser(rows, &u); err != nil {
+					return err
+				}
+				users = append(users, u)
+				return nil
+			})
+	})
+	return users, err
+}
+
+func (s *postgresStore) Filter(ctx context.Context, f UserFilter) ([]User, int, error) {
+	order, err := orderBy(f.Sort)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 👇 Only these fixed conditions reach the SQL; every value is a parameter
+	var conds []string
+	var args []interface{}
+	where := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if f.NameContains != "" {
+		where("strpos(lower(name), lower($%d)) > 0", f.NameContains)
+	}
+	if !f.CreatedAfter.IsZero() {
+		where("created_at >= $%d", f.CreatedAfter)
+	}
+	if !f.CreatedBefore.IsZero() {
+		where("created_at < $%d", f.CreatedBefore)
+	}
+	from := " FROM users"
+	if len(conds) > 0 {
+		from += " WHERE " + strings.Join(conds, " AND ")
+	}
+	page := fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+
+	users := []User{}
+	var total int
+	err = s.read(func() error {
+		users = users[:0]
+		err := s.reader().query(ctx, "filter", "SELECT "+userColumns+from+order+page, append(args, f.Limit, f.Offset),
+			func(rows *sql.Rows) error {
+				var u User
+				if err := scanUser(rows, &u); err != nil {
+					return err
+				}
+				users = append(users, u)
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+		return s.reader().queryRow(ctx, "filter_count", "SELECT COUNT(*)"+from, args, &total)
+	})
+	return users, total, err
+}
+
+func (s *postgresStore) Extremes(ctx context.Context) (oldest, newest *User, err error) {
+	if oldest, err = s.userByCreatedAt(ctx, "ASC"); err != nil {
+		return nil, nil, err
+	}
+	if newest, err = s.userByCreatedAt(ctx, "DESC"); err != nil {
+		return nil, nil, err
+	}
+	return oldest, newest, nil
+}
+
+// userByCreatedAt returns the first user in created_at order ("ASC" or
+// "DESC"), or nil when the table is empty
+func (s *postgresStore) userByCreatedAt(ctx context.Context, direction string) (*User, error) {
+	var u User
+	err := s.read(func() error {
+		return s.reader().queryRow(ctx, "extremes",
+			"SELECT "+userColumns+" FROM users ORDER BY created_at "+direction+", id "+direction+" LIMIT 1", nil, userFields(&u)...)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sony/gobreaker"
+)
+
+func TestReadRetriesOnceAfterDroppedConnection(t *testing.T) {
+	syntaxErr := errors.New("syntax error")
+	shutdown := &pq.Error{Code: "57P01"}
+	for _, tc := range []struct {
+		name      string
+		retry     bool
+		errs      []error // what each attempt returns
+		wantCalls int
+		wantErr   error
+	}{
+		{"dropped connection", true, []error{driver.ErrBadConn, nil}, 2, nil},
+		{"admin shutdown", true, []error{shutdown, nil}, 2, nil},
+		{"dropped twice", true, []error{io.EOF, io.EOF}, 2, io.EOF},
+		{"opt-in only", false, []error{driver.ErrBadConn, nil}, 1, driver.ErrBadConn},
+		{"query error", true, []error{syntaxErr, nil}, 1, syntaxErr},
+		{"breaker open", true, []error{gobreaker.ErrOpenState, nil}, 1, gobreaker.ErrOpenState},
+	} {
+		s := &postgresStore{retryReads: tc.retry}
+		calls := 0
+		err := s.read(func() error {
+			calls++
+			return tc.errs[calls-1]
+		})
+		if calls != tc.wantCalls {
+			t.Errorf("%s: %d attempts, want %d", tc.name, calls, tc.wantCalls)
+		}
+		if err != tc.wantErr {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	for v, want := range map[string]string{
+		"name,-created_at": " ORDER BY name, created_at DESC, id",
+		"-id":              " ORDER BY id DESC",
+		"updated_at,-id":   " ORDER BY updated_at, id DESC",
+	} {
+		fields, err := parseSort(v)
+		if err != nil {
+			t.Fatalf("parseSort(%q): %v", v, err)
+		}
+		if got, err := orderBy(fields); err != nil || got != want {
+			t.Errorf("orderBy(%q) = %q, %v; want %q", v, got, err, want)
+		}
+	}
+	if got, _ := orderBy(nil); got != " ORDER BY id" {
+		t.Errorf("orderBy(nil) = %q, want id only", got)
+	}
+	// Fields that skipped parseSort are still checked
+	if _, err := orderBy([]SortField{{Column: "name; DROP TABLE users"}}); err == nil {
+		t.Error("orderBy accepted a column outside sortColumns")
+	}
+}
+
+// stubRows is a database connector whose every query returns rows, then
+// fails with err, standing in for a connection lost mid-result. With hang
+// set, queries instead block until their context ends; with connectErr,
+// no connection can be made at all. Queries are recorded in queries when
+// it is set.
+type stubRows struct {
+	rows       [][]driver.Value
+	err        error
+	hang       bool
+	connectErr error
+	queries    *[]string
+}
+
+func (s stubRows) Connect(context.Context) (driver.Conn, error) {
+	if s.connectErr != nil {
+		return nil, s.connectErr
+	}
+	return s, nil
+}
+func (s stubRows) Driver() driver.Driver               { return nil }
+func (s stubRows) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (s stubRows) Close() error                        { return nil }
+func (s stubRows) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (s stubRows) QueryContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if s.queries != nil {
+		*s.queries = append(*s.queries, query)
+	}
+	if s.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &stubCursor{rows: s.rows, err: s.err}, nil
+}
+
+type stubCursor struct {
+	rows [][]driver.Value
+	err  error
+}
+
+func (c *stubCursor) Columns() []string { return []string{"id", "name", "created_at", "updated_at"} }
+func (c *stubCursor) Close() error      { return nil }
+
+func (c *stubCursor) Next(dest []driver.Value) error {
+	if len(c.rows) == 0 {
+		if c.err != nil {
+			return c.err
+		}
+		return io.EOF
+	}
+	copy(dest, c.rows[0])
+	c.rows = c.rows[1:]
+	return nil
+}
+
+func TestListFailsOnRowErrors(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ada := []driver.Value{int64(1), "Ada", now, now}
+	lost := errors.New("connection lost mid-result")
+	for _, tc := range []struct {
+		name string
+		rows stubRows
+	}{
+		{"error after the first row", stubRows{rows: [][]driver.Value{ada}, err: lost}},
+		{"row that fails to scan", stubRows{rows: [][]driver.Value{ada, {int64(2), nil, now, now}}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := sql.OpenDB(tc.rows)
+			defer db.Close()
+			ps := &postgresStore{db: db}
+
+			if users, err := ps.List(context.Background(), ListOptions{}); err == nil {
+				t.Fatalf("List returned %d users and no error", len(users))
+			}
+
+			prev := store
+			store = ps
+			t.Cleanup(func() { store = prev })
+			if rec := serve(newTestHandler(testConfig(t)), "GET", "/api/users", ""); rec.Code != http.StatusInternalServerError {
+				t.Errorf("GET /api/users = %d, want 500: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+
+	db := sql.OpenDB(stubRows{rows: [][]driver.Value{ada}})
+	defer db.Close()
+	if users, err := (&postgresStore{db: db}).List(context.Background(), ListOptions{}); err != nil || len(users) != 1 {
+		t.Errorf("List = %v, %v; want Ada", users, err)
+	}
+}
+
+// acquireWaits is how many connection waits db_conn_acquire_seconds has seen
+func acquireWaits() uint64 {
+	var m dto.Metric
+	dbConnAcquire.Write(&m)
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestExhaustedPoolFailsFast(t *testing.T) {
+	db := sql.OpenDB(stubRows{})
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	held, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+
+	ps := &postgresStore{db: db, acquireTimeout: 50 * time.Millisecond}
+	waits := acquireWaits()
+	start := time.Now()
+	_, err = ps.List(context.Background(), ListOptions{})
+	if !errors.Is(err, errAcquireTimeout) {
+		t.Fatalf("err = %v, want errAcquireTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("List took %s with a 50ms acquire timeout", elapsed)
+	}
+	if acquireWaits() != waits+1 {
+		t.Error("the wait was not recorded in db_conn_acquire_seconds")
+	}
+
+	prev := store
+	store = ps
+	t.Cleanup(func() { store = prev })
+	rec := serve(newTestHandler(testConfig(t)), "GET", "/api/users", "")
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "no connection available") {
+		t.Errorf("GET /api/users = %d %s, want 503 no connection available", rec.Code, rec.Body)
+	}
+}
+
+func TestSlowQueryIsNotAnAcquireTimeout(t *testing.T) {
+	db := sql.OpenDB(stubRows{hang: true})
+	defer db.Close()
+
+	ps := &postgresStore{db: db, acquireTimeout: time.Second, queryTimeout: 50 * time.Millisecond}
+	_, err := ps.List(context.Background(), ListOptions{})
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errAcquireTimeout) {
+		t.Fatalf("err = %v, want a query deadline", err)
+	}
+
+	prev := store
+	store = ps
+	t.Cleanup(func() { store = prev })
+	rec := serve(newTestHandler(testConfig(t)), "GET", "/api/users", "")
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "query timed out") {
+		t.Errorf("GET /api/users = %d %s, want 503 query timed out", rec.Code, rec.Body)
+	}
+}
+
+func TestQueryTags(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tagged := range []bool{false, true} {
+		var queries []string
+		db := sql.OpenDB(stubRows{rows: [][]driver.Value{{int64(1), "Ada", now, now}}, queries: &queries})
+		defer db.Close()
+
+		users, err := (&postgresStore{db: db, tagQueries: tagged}).List(context.Background(), ListOptions{})
+		if err != nil || len(users) != 1 {
+			t.Fatalf("tagged=%t: List = %v, %v", tagged, users, err)
+		}
+		if len(queries) != 1 {
+			t.Fatalf("tagged=%t: %d queries, want 1", tagged, len(queries))
+		}
+		if got := strings.HasPrefix(queries[0], "/* op=list */ SELECT "); got != tagged {
+			t.Errorf("tagged=%t: query = %q", tagged, queries[0])
+		}
+	}
+	if cfg := testConfig(t); cfg.DBQueryTags {
+		t.Error("DB_QUERY_TAGS is on by default")
+	}
+}
@@ -0,0 +1,880 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// User represents a user in our database
+type User struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// jsonCamelCase switches response field names to camelCase (JSON_NAMING=camel)
+var jsonCamelCase bool
+
+// timeFormat is how user timestamps are encoded (TIME_FORMAT): "rfc3339",
+// "unix" (seconds) or "unixmilli"
+var timeFormat = "rfc3339"
+
+// jsonTime is a time.Time encoded in timeFormat
+type jsonTime time.Time
+
+func (t jsonTime) MarshalJSON() ([]byte, error) {
+	switch timeFormat {
+	case "unix":
+		return strconv.AppendInt(nil, time.Time(t).Unix(), 10), nil
+	case "unixmilli":
+		return strconv.AppendInt(nil, time.Time(t).UnixMilli(), 10), nil
+	}
+	return time.Time(t).MarshalJSON()
+}
+
+// MarshalJSON uses snake_case names by default and camelCase when
+// JSON_NAMING=camel, so clients don't need a mapping layer. Timestamps
+// follow TIME_FORMAT.
+func (u User) MarshalJSON() ([]byte, error) {
+	if !jsonCamelCase {
+		return json.Marshal(struct {
+			ID        int      `json:"id"`
+			Name      string   `json:"name"`
+			CreatedAt jsonTime `json:"created_at"`
+			UpdatedAt jsonTime `json:"updated_at"`
+		}{u.ID, u.Name, jsonTime(u.CreatedAt), jsonTime(u.UpdatedAt)})
+	}
+	return json.Marshal(struct {
+		ID        int      `json:"id"`
+		Name      string   `json:"name"`
+		CreatedAt jsonTime `json:"createdAt"`
+		UpdatedAt jsonTime `json:"updatedAt"`
+	}{u.ID, u.Name, jsonTime(u.CreatedAt), jsonTime(u.UpdatedAt)})
+}
+
+// maxNameLength matches the VARCHAR(100) name column
+const maxNameLength = 100
+
+// validateName trims name and checks it fits the users table
+func validateName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("name is required")
+	}
+	if len([]rune(name)) > maxNameLength {
+		return "", fmt.Errorf("name must be at most %d characters", maxNameLength)
+	}
+	return name, nil
+}
+
+// parseID reads the {id} path parameter
+func parseID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		return 0, errors.New("invalid user id")
+	}
+	return id, nil
+}
+
+// requestError is a 400 caused by the request body, with optional details
+type requestError struct {
+	message string
+	details []fieldError
+}
+
+// fieldError is one problem with a request body. Field is the offending
+// property ("" for the body as a whole).
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *requestError) Error() string { return e.message }
+
+// errUnsupportedMediaType is returned for write bodies that aren't JSON
+var errUnsupportedMediaType = errors.New("Content-Type must be application/json")
+
+// requireJSON checks the request declares a JSON body. Parameters such as
+// "; charset=utf-8" are allowed.
+func requireJSON(r *http.Request) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return errUnsupportedMediaType
+	}
+	return nil
+}
+
+// errBodyTooLarge is returned for bodies over the limit set by limitBodySize
+var errBodyTooLarge = errors.New("request body is too large")
+
+// decodeJSON reads a write request's body into dst, applying the rules
+// every JSON endpoint shares: Content-Type must be JSON, the body within
+// its limitBodySize cap and a single JSON value, and unknown fields are
+// rejected. Errors are errUnsupportedMediaType, errBodyTooLarge or a
+// *requestError, ready for writeRequestError.
+func decodeJSON(r *http.Request, dst interface{}) error {
+	if err := requireJSON(r); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	var tooLarge *http.MaxBytesError
+	err := dec.Decode(dst)
+	if err == nil {
+		// 👇 Checking for trailing data can be what runs into the size cap
+		if err = dec.Decode(&struct{}{}); err == io.EOF {
+			err = nil
+		} else if !errors.As(err, &tooLarge) {
+			err = errors.New("trailing data after JSON value")
+		}
+	}
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &tooLarge):
+		return errBodyTooLarge
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return &requestError{message: "unknown field " + field}
+	}
+	return &requestError{message: "invalid JSON body"}
+}
+
+// writeRequestError sends a 400 for err, including any details (415 for a
+// body that isn't JSON, 413 for one that is too large)
+func writeRequestError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUnsupportedMediaType):
+		writeError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	case errors.Is(err, errBodyTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+
+	var re *requestError
+	if errors.As(err, &re) && len(re.details) > 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":   localize(w, re.message),
+			"details": re.details,
+		})
+		return
+	}
+	writeError(w, http.StatusBadRequest, err.Error())
+}
+
+// decodeUserRequest reads a create/update body, checks it against the
+// embedded JSON Schema and returns the validated name
+func decodeUserRequest(r *http.Request) (string, error) {
+	// 👇 Decoded generically so the schema sees the body exactly as sent
+	var doc interface{}
+	if err := decodeJSON(r, &doc); err != nil {
+		return "", err
+	}
+
+	if err := userSchema.Validate(doc); err != nil {
+		// 👇 Lead with typos like {"nmae": "x"} rather than a confusing "name
+		// is required"; details still lists every violation
+		message := "request body does not match schema"
+		if field := unknownUserField(doc); field != "" {
+			message = "unknown field " + strconv.Quote(field)
+		}
+		return "", &requestError{message: message, details: schemaViolations(err)}
+	}
+	// The schema guarantees an object with a string name
+	name, err := validateName(doc.(map[string]interface{})["name"].(string))
+	if err != nil {
+		return "", &requestError{message: err.Error(), details: []fieldError{{Field: "name", Message: err.Error()}}}
+	}
+	return name, nil
+}
+
+// unknownUserField returns the first (alphabetically) property of doc that
+// isn't name, or "" if there is none
+func unknownUserField(doc interface{}) string {
+	obj, _ := doc.(map[string]interface{})
+	var unknown []string
+	for key := range obj {
+		if key != "name" {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	if len(unknown) == 0 {
+		return ""
+	}
+	return unknown[0]
+}
+
+// writeStoreError maps a UserStore error to a response
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "user not found")
+	case errors.Is(err, ErrAmbiguous):
+		writeError(w, http.StatusConflict, "multiple users match")
+	case errors.Is(err, ErrModified):
+		writeError(w, http.StatusPreconditionFailed, "user was modified")
+	case errors.Is(err, ErrDuplicate):
+		writeError(w, http.StatusConflict, "user already exists")
+	case errors.Is(err, ErrValidation):
+		writeError(w, http.StatusBadRequest, "invalid user data")
+	default:
+		writeDBError(w, err)
+	}
+}
+
+// usersHandler returns one page of users from the database, sized with
+// ?limit=N&offset=M
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	users, err := store.List(r.Context(), opts)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	total, err := store.Count(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if link := paginationLinks(r.URL, opts.Limit, opts.Offset, total); link != "" {
+		w.Header().Set("Link", link)
+	}
+	if len(opts.Fields) > 0 {
+		writeJSON(w, http.StatusOK, projectUsers(users, opts.Fields))
+		return
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+// camelColumns maps the camelCase JSON names to their columns
+var camelColumns = map[string]string{"createdAt": "created_at", "updatedAt": "updated_at"}
+
+// parseFields reads ?fields=id,name. Columns may be given in either JSON
+// naming (created_at or createdAt); duplicates are dropped.
+func parseFields(v string) ([]string, error) {
+	var fields []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(v, ",") {
+		column := part
+		if c, ok := camelColumns[part]; ok {
+			column = c
+		}
+		if selectColumns[column] == nil {
+			return nil, fmt.Errorf("invalid field %q", part)
+		}
+		if !seen[column] {
+			seen[column] = true
+			fields = append(fields, column)
+		}
+	}
+	return fields, nil
+}
+
+// projectUsers keeps only the selected columns of each user, named the way
+// User.MarshalJSON would name them
+func projectUsers(users []User, fields []string) []map[string]interface{} {
+	out := make([]map[string]interface{}, len(users))
+	for i, u := range users {
+		m := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			switch f {
+			case "id":
+				m["id"] = u.ID
+			case "name":
+				m["name"] = u.Name
+			case "created_at":
+				if jsonCamelCase {
+					m["createdAt"] = jsonTime(u.CreatedAt)
+				} else {
+					m["created_at"] = jsonTime(u.CreatedAt)
+				}
+			case "updated_at":
+				if jsonCamelCase {
+					m["updatedAt"] = jsonTime(u.UpdatedAt)
+				} else {
+					m["updated_at"] = jsonTime(u.UpdatedAt)
+				}
+			}
+		}
+		out[i] = m
+	}
+	return out
+}
+
+// parseListOptions reads the paging, fields and sort query params
+func parseListOptions(r *http.Request) (ListOptions, error) {
+	var opts ListOptions
+	var err error
+	if opts.Limit, opts.Offset, err = parsePagination(r); err != nil {
+		return opts, err
+	}
+	q := r.URL.Query()
+	if v := q.Get("fields"); v != "" {
+		fields, err := parseFields(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.Fields = fields
+	}
+	if v := q.Get("sort"); v != "" {
+		sort, err := parseSort(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.Sort = sort
+	}
+	return opts, nil
+}
+
+// parseSort reads ?sort=name,-created_at: comma-separated columns applied in
+// order, each descending when prefixed with "-". Like parseFields it takes
+// either JSON naming (-createdAt). One bad field rejects the whole list.
+func parseSort(v string) ([]SortField, error) {
+	var fields []SortField
+	for _, part := range strings.Split(v, ",") {
+		column, desc := strings.CutPrefix(part, "-")
+		if c, ok := camelColumns[column]; ok {
+			column = c
+		}
+		if !sortColumns[column] {
+			return nil, fmt.Errorf("invalid sort field %q", part)
+		}
+		fields = append(fields, SortField{Column: column, Desc: desc})
+	}
+	return fields, nil
+}
+
+// getUserHandler returns a single user by ID
+func getUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	u, err := store.Get(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", userETag(u))
+	if notModifiedSince(w, r, u.UpdatedAt) {
+		return
+	}
+	writeJSON(w, http.StatusOK, userResponse{User: u, Links: userLinks(r, u)})
+}
+
+// userETag is a user's entity tag, an opaque hash of its ID and updated_at,
+// so it changes with every write
+func userETag(u User) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%d", u.ID, u.UpdatedAt.UnixMicro())))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// getUserByNameHandler looks a user up by name (?name=Alice), ignoring case.
+// Names aren't unique, so several matches are reported as a 409.
+func getUserByNameHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := validateName(r.URL.Query().Get("name"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	u, err := store.GetByName(r.Context(), name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// upsertUserByNameHandler creates the user named in the path, or renames the
+// existing user with that name (ignoring case) to this exact spelling. A new
+// user is a 201 with Location, an existing one a 200, so provisioning scripts
+// can run it repeatedly.
+func upsertUserByNameHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := validateName(r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	u, created, err := store.UpsertByName(r.Context(), name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !created {
+		writeJSON(w, http.StatusOK, u)
+		return
+	}
+	usersTotal.Inc()
+	w.Header().Set("Location", userPath(u.ID))
+	writeJSON(w, http.StatusCreated, u)
+}
+
+// createUserHandler adds a new user
+func createUserHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := decodeUserRequest(r)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	u, err := store.Create(r.Context(), name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	usersTotal.Inc()
+	w.Header().Set("Location", userPath(u.ID))
+	writeJSON(w, http.StatusCreated, userResponse{User: u, Warnings: nameWarnings(u.Name), Links: userLinks(r, u)})
+}
+
+// userPath is the URL path of user id
+func userPath(id int) string {
+	return fmt.Sprintf("%s/api/users/%d", basePath, id)
+}
+
+// halMediaType is the Accept type with which clients ask for "_links"
+const halMediaType = "application/hal+json"
+
+// userLinks returns the "_links" for u when the client's Accept header asks
+// for hypermedia, and nil otherwise so plain clients see the usual object
+func userLinks(r *http.Request, u User) map[string]string {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, _ := mime.ParseMediaType(accept); mediaType == halMediaType {
+			return map[string]string{"self": userPath(u.ID)}
+		}
+	}
+	return nil
+}
+
+// userResponse is a user plus optional extras: the soft warnings about a
+// created user and hypermedia links
+type userResponse struct {
+	User
+	Warnings []string
+	Links    map[string]string
+}
+
+// MarshalJSON adds "warnings" and "_links", when set, to the user's own
+// fields, so clients that ignore them see the usual user object
+func (u userResponse) MarshalJSON() ([]byte, error) {
+	out, err := json.Marshal(u.User)
+	if err != nil {
+		return nil, err
+	}
+	for _, extra := range []struct {
+		key   string
+		value interface{}
+		set   bool
+	}{
+		{"warnings", u.Warnings, len(u.Warnings) > 0},
+		{"_links", u.Links, len(u.Links) > 0},
+	} {
+		if !extra.set {
+			continue
+		}
+		value, err := json.Marshal(extra.value)
+		if err != nil {
+			return nil, err
+		}
+		// 👇 out is an object ending in "}": splice the extra key in before it
+		out = append(out[:len(out)-1:len(out)-1], `,"`+extra.key+`":`...)
+		out = append(append(out, value...), '}')
+	}
+	return out, nil
+}
+
+// updateUserHandler renames an existing user
+func updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name, err := decodeUserRequest(r)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	u, err := updateUser(r, id, name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", userETag(u))
+	writeJSON(w, http.StatusOK, u)
+}
+
+// updateUser renames user id, honouring If-Match: the update only applies
+// if the user's current ETag is listed. The version is read from the
+// primary (a lagging replica could vouch for a stale ETag) and checked
+// again by the UPDATE itself, so a write that lands in between still gets
+// ErrModified. A user that is gone fails the precondition too.
+func updateUser(r *http.Request, id int, name string) (User, error) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || strings.TrimSpace(ifMatch) == "*" {
+		return store.Update(r.Context(), id, name)
+	}
+
+	current, err := store.GetPrimary(r.Context(), id)
+	if errors.Is(err, ErrNotFound) {
+		return User{}, ErrModified
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if !etagListed(ifMatch, userETag(current)) {
+		return User{}, ErrModified
+	}
+	return store.UpdateIfUnmodified(r.Context(), id, name, current.UpdatedAt)
+}
+
+// testEndpointsEnabled is false in production (APP_ENV), where test-only
+// endpoints answer 403
+var testEndpointsEnabled bool
+
+// setCreatedAtHandler backdates a user from {"created_at": "<RFC 3339>"}, so
+// date-dependent features can be tested without direct SQL access
+func setCreatedAtHandler(w http.ResponseWriter, r *http.Request) {
+	if !testEndpointsEnabled {
+		writeError(w, http.StatusForbidden, "not available in production")
+		return
+	}
+	id, err := parseID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var req struct {
+		CreatedAt string `json:"created_at"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	createdAt, err := time.Parse(time.RFC3339, req.CreatedAt)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "created_at must be an RFC 3339 timestamp")
+		return
+	}
+
+	u, err := store.SetCreatedAt(r.Context(), id, createdAt.UTC())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// deleteUserHandler removes a user
+func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := store.Delete(r.Context(), id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	usersTotal.Dec()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maxBatchRenames caps how many users one PATCH /api/users may rename
+const maxBatchRenames = 100
+
+// renameResult is one entry of the batch rename response
+type renameResult struct {
+	ID    int    `json:"id"`
+	User  *User  `json:"user,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// renameUsersHandler renames several users at once from
+// {"updates": [{"id": 1, "name": "X"}, ...]}. The batch is all-or-nothing
+// (422 if any item fails) unless ?partial=true, which applies every item
+// that can be applied.
+func renameUsersHandler(w http.ResponseWriter, r *http.Request) {
+	partial, err := strconv.ParseBool(r.URL.Query().Get("partial"))
+	if err != nil && r.URL.Query().Has("partial") {
+		writeError(w, http.StatusBadRequest, "partial must be true or false")
+		return
+	}
+
+	var req struct {
+		Updates []Rename `json:"updates"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if len(req.Updates) == 0 || len(req.Updates) > maxBatchRenames {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("updates must contain 1 to %d items", maxBatchRenames))
+		return
+	}
+
+	// Invalid names never reach the database; the rest run as one batch
+	results := make([]renameResult, len(req.Updates))
+	var valid []Rename
+	var validIdx []int
+	for i, rn := range req.Updates {
+		results[i].ID = rn.ID
+		name, err := validateName(rn.Name)
+		if err == nil && rn.ID <= 0 {
+			err = errors.New("invalid user id")
+		}
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		valid = append(valid, Rename{ID: rn.ID, Name: name})
+		validIdx = append(validIdx, i)
+	}
+
+	applied := partial || len(valid) == len(req.Updates)
+	if applied && len(valid) > 0 {
+		renamed, err := store.RenameMany(r.Context(), valid, partial)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		for j, res := range renamed {
+			if res.Err != nil {
+				results[validIdx[j]].Error = res.Err.Error()
+				applied = partial
+				continue
+			}
+			u := res.User
+			results[validIdx[j]].User = &u
+		}
+	}
+
+	status := http.StatusOK
+	if !applied {
+		status = http.StatusUnprocessableEntity
+		for i := range results {
+			if results[i].Error == "" {
+				results[i].User = nil
+				results[i].Error = errRolledBack.Error()
+			}
+		}
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"applied": applied,
+		"results": results,
+	})
+}
+
+// searchUsersHandler finds users by the words in ?q=, ranked by relevance
+// with Postgres full-text search, and paged with limit and offset. It is
+// a 404 while the fts_search flag is off.
+func searchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if !flags.Enabled("fts_search") {
+		writeError(w, http.StatusNotFound, "full-text search is disabled")
+		return
+	}
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	users, err := store.Search(r.Context(), query, limit, offset)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+// filterRequest is the body of POST /api/users/search. Every field is
+// optional and the criteria that are given must all match.
+type filterRequest struct {
+	NameContains  string     `json:"name_contains"`
+	CreatedAfter  *time.Time `json:"created_after"`
+	CreatedBefore *time.Time `json:"created_before"`
+	Sort          string     `json:"sort"` // as in ?sort=, e.g. "-created_at,name"
+	Limit         *int       `json:"limit"`
+	Offset        int        `json:"offset"`
+}
+
+// filterUsersHandler finds users matching a JSON filter, which keeps
+// combined criteria out of the URL. The response carries the page and the
+// total number of matches.
+func filterUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var req filterRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	f, err := req.filter()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	users, total, err := store.Filter(r.Context(), f)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"users":  users,
+		"total":  total,
+		"limit":  f.Limit,
+		"offset": f.Offset,
+	})
+}
+
+// filter validates the request and turns it into a UserFilter
+func (req filterRequest) filter() (UserFilter, error) {
+	f := UserFilter{
+		NameContains: strings.TrimSpace(req.NameContains),
+		Limit:        pageSizeDefault,
+		Offset:       req.Offset,
+	}
+	if len([]rune(f.NameContains)) > maxNameLength {
+		return f, fmt.Errorf("name_contains must be at most %d characters", maxNameLength)
+	}
+	// 👇 created_at is a TIMESTAMP without time zone, which would drop an
+	// offset like +02:00 instead of converting it; compare in UTC like
+	// SetCreatedAt stores
+	if req.CreatedAfter != nil {
+		f.CreatedAfter = req.CreatedAfter.UTC()
+	}
+	if req.CreatedBefore != nil {
+		f.CreatedBefore = req.CreatedBefore.UTC()
+	}
+	if req.CreatedAfter != nil && req.CreatedBefore != nil && !f.CreatedAfter.Before(f.CreatedBefore) {
+		return f, errors.New("created_after must be before created_before")
+	}
+	if req.Sort != "" {
+		sort, err := parseSort(req.Sort)
+		if err != nil {
+			return f, err
+		}
+		f.Sort = sort
+	}
+	if req.Limit != nil {
+		limit, err := checkLimit(*req.Limit)
+		if err != nil {
+			return f, err
+		}
+		f.Limit = limit
+	}
+	if f.Offset < 0 {
+		return f, errors.New("offset must be a non-negative integer")
+	}
+	return f, nil
+}
+
+// maxRecentWindow caps ?window= on GET /api/users/recent-count
+const maxRecentWindow = 365 * 24 * time.Hour
+
+// recentCountHandler counts the users created in the last ?window= (a Go
+// duration, 24h by default), e.g. for a "new users today" widget
+func recentCountHandler(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query().Get("window")
+	if v == "" {
+		v = "24h"
+	}
+	window, err := time.ParseDuration(v)
+	if err != nil || window <= 0 || window > maxRecentWindow {
+		writeError(w, http.StatusBadRequest, "window must be a positive duration of at most 8760h, e.g. 24h")
+		return
+	}
+
+	n, err := store.CountSince(r.Context(), window)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"window": v, "count": n})
+}
+
+// userExtremesHandler returns the oldest and newest users (null when empty)
+func userExtremesHandler(w http.ResponseWriter, r *http.Request) {
+	oldest, newest, err := store.Extremes(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]*User{
+		"oldest": oldest,
+		"newest": newest,
+	})
+}
+
+// maxBulkIDs caps how many users one PATCH /api/users/bulk may touch
+const maxBulkIDs = 1000
+
+// bulkUpdateUsersHandler applies one change to many users,
+// {"ids": [1, 2, 3], "name": "..."}, and reports how many were updated.
+// It is a single UPDATE, so either every listed user changes or none does.
+func bulkUpdateUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs  []int  `json:"ids"`
+		Name string `json:"name"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if len(req.IDs) == 0 || len(req.IDs) > maxBulkIDs {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("ids must contain 1 to %d items", maxBulkIDs))
+		return
+	}
+	for _, id := range req.IDs {
+		if id <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid user id")
+			return
+		}
+	}
+	name, err := validateName(req.Name)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	n, err := store.RenameAll(r.Context(), req.IDs, name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"updated": n})
+}
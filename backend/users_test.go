@@ -0,0 +1,560 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decodeUser(t *testing.T, body string) User {
+	t.Helper()
+	var u struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(body), &u); err != nil {
+		t.Fatalf("decoding %q: %v", body, err)
+	}
+	return User{ID: u.ID, Name: u.Name}
+}
+
+func TestCreateUser(t *testing.T) {
+	fs := useFakeStore(t)
+	h := newTestHandler(testConfig(t))
+
+	rec := serve(h, "POST", "/api/users", `{"name": "  Ada Lovelace "}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body)
+	}
+	u := decodeUser(t, rec.Body.String())
+	if u.Name != "Ada Lovelace" {
+		t.Errorf("name = %q, want it trimmed", u.Name)
+	}
+	if got := rec.Header().Get("Location"); got != "/api/users/1" {
+		t.Errorf("Location = %q, want /api/users/1", got)
+	}
+	if n, _ := fs.Count(context.Background()); n != 1 {
+		t.Errorf("store holds %d users, want 1", n)
+	}
+}
+
+func TestCreateUserRejectsInvalidNames(t *testing.T) {
+	fs := useFakeStore(t)
+	h := newTestHandler(testConfig(t))
+
+	for _, body := range []string{
+		`{"name": ""}`,
+		`{"name": "   "}`,
+		`{"name": "` + strings.Repeat("x", maxNameLength+1) + `"}`,
+		`{}`,
+		`not json`,
+	} {
+		if rec := serve(h, "POST", "/api/users", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST %s: status = %d, want 400", body, rec.Code)
+		}
+	}
+	if n, _ := fs.Count(context.Background()); n != 0 {
+		t.Errorf("store holds %d users, want none", n)
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	useFakeStore(t, "Ada", "Grace")
+	h := newTestHandler(testConfig(t))
+
+	rec := serve(h, "GET", "/api/users/2", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if u := decodeUser(t, rec.Body.String()); u.ID != 2 || u.Name != "Grace" {
+		t.Errorf("got %+v, want user 2 Grace", u)
+	}
+
+	for path, want := range map[string]int{
+		"/api/users/99":  http.StatusNotFound,
+		"/api/users/abc": http.StatusBadRequest,
+		"/api/users/0":   http.StatusBadRequest,
+	} {
+		if rec := serve(h, "GET", path, ""); rec.Code != want {
+			t.Errorf("GET %s: status = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	fs := useFakeStore(t, "Ada")
+	h := newTestHandler(testConfig(t))
+
+	for _, method := range []string{"PUT", "PATCH"} {
+		rec := serve(h, method, "/api/users/1", `{"name": "Ada `+method+`"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200: %s", method, rec.Code, rec.Body)
+		}
+		if u, _ := fs.Get(context.Background(), 1); u.Name != "Ada "+method {
+			t.Errorf("%s: stored name = %q", method, u.Name)
+		}
+	}
+
+	if rec := serve(h, "PUT", "/api/users/2", `{"name": "Nobody"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown user: status = %d, want 404", rec.Code)
+	}
+	if rec := serve(h, "PUT", "/api/users/1", `{"name": ""}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("empty name: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	useFakeStore(t, "Ada")
+	h := newTestHandler(testConfig(t))
+
+	if rec := serve(h, "DELETE", "/api/users/1", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204", rec.Code)
+	}
+	if rec := serve(h, "DELETE", "/api/users/1", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second delete: status = %d, want 404", rec.Code)
+	}
+	if rec := serve(h, "GET", "/api/users/1", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("get after delete: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	useFakeStore(t, "Ada", "Grace", "Linus")
+	h := newTestHandler(testConfig(t))
+
+	rec := serve(h, "GET", "/api/users", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var users []User
+	if err := json.Unmarshal(rec.Body.Bytes(), &users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 || users[0].Name != "Ada" || users[2].Name != "Linus" {
+		t.Errorf("got %+v, want Ada, Grace and Linus", users)
+	}
+}
+
+func TestStoreFailureIs503(t *testing.T) {
+	fs := useFakeStore(t, "Ada")
+	fs.err = driver.ErrBadConn
+	h := newTestHandler(testConfig(t))
+
+	for _, path := range []string{"/api/users", "/api/users/1"} {
+		rec := serve(h, "GET", path, "")
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("GET %s: status = %d, want 503", path, rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "bad connection") {
+			t.Errorf("GET %s leaked the driver error: %s", path, rec.Body)
+		}
+	}
+}
+
+func TestFilterUsers(t *testing.T) {
+	fs := useFakeStore(t, "Ada Lovelace", "Alan Turing", "Grace Hopper", "Alan Kay", "Alan Perlis")
+	for i := range fs.users {
+		fs.users[i].CreatedAt = time.Date(2024, 1, 1+i, 0, 0, 0, 0, time.UTC)
+	}
+	h := newTestHandler(testConfig(t))
+
+	// Name, both dates (one with an offset), sort and limit together
+	rec := serve(h, "POST", "/api/users/search", `{
+		"name_contains": "ALAN",
+		"created_after": "2024-01-02T02:00:00+02:00",
+		"created_before": "2024-01-05T00:00:00Z",
+		"sort": "-name",
+		"limit": 1
+	}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Users []User `json:"users"`
+		Total int    `json:"total"`
+		Limit int    `json:"limit"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Total != 2 || resp.Limit != 1 || len(resp.Users) != 1 || resp.Users[0].Name != "Alan Turing" {
+		t.Errorf("got %s, want Alan Turing of 2 matches", rec.Body)
+	}
+	if got := rec.Header().Get("X-Total-Count"); got != "2" {
+		t.Errorf("X-Total-Count = %q, want 2", got)
+	}
+
+	for _, body := range []string{
+		`{"created_after": "2024-01-03T00:00:00Z", "created_before": "2024-01-02T00:00:00Z"}`,
+		`{"sort": "password"}`,
+		`{"limit": 0}`,
+		`{"offset": -1}`,
+		`{"name_cointains": "a"}`,
+	} {
+		if rec := serve(h, "POST", "/api/users/search", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST %s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestFilterRequestUsesUTC(t *testing.T) {
+	after := time.Date(2024, 1, 2, 2, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	before := after.Add(time.Hour)
+	f, err := filterRequest{CreatedAfter: &after, CreatedBefore: &before}.filter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// lib/pq sends the wall clock and offset; a TIMESTAMP column keeps only
+	// the wall clock, so it has to be UTC already
+	if f.CreatedAfter.Location() != time.UTC || f.CreatedAfter.Hour() != 0 || f.CreatedBefore.Location() != time.UTC {
+		t.Errorf("filter bounds = %v, %v; want them in UTC", f.CreatedAfter, f.CreatedBefore)
+	}
+}
+
+func TestCreateUserReportsSchemaViolations(t *testing.T) {
+	useFakeStore(t)
+	h := newTestHandler(testConfig(t))
+
+	rec := serve(h, "POST", "/api/users", `{"name": "`+strings.Repeat("x", 120)+`", "nmae": 1}`)
+	var resp struct {
+		Error   string       `json:"error"`
+		Details []fieldError `json:"details"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusBadRequest || resp.Error != `unknown field "nmae"` || len(resp.Details) != 2 {
+		t.Errorf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(h, "POST", "/api/users", `{"name": 42}`)
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusBadRequest || resp.Error != "request body does not match schema" {
+		t.Errorf("wrong type: status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
+
+func TestUpdateUserIfMatch(t *testing.T) {
+	fs := useFakeStore(t, "Ada")
+	h := newTestHandler(testConfig(t))
+
+	etag := serve(h, "GET", "/api/users/1", "").Header().Get("ETag")
+	if rec := serve(h, "PUT", "/api/users/1", `{"name": "Ada Lovelace"}`, "If-Match", etag); rec.Code != http.StatusOK {
+		t.Fatalf("current ETag: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	// The rename changed the ETag, so replaying the old one fails
+	if rec := serve(h, "PUT", "/api/users/1", `{"name": "Ada King"}`, "If-Match", etag); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale ETag: status = %d, want 412", rec.Code)
+	}
+	if u, _ := fs.Get(context.Background(), 1); u.Name != "Ada Lovelace" {
+		t.Errorf("name = %q after a failed precondition", u.Name)
+	}
+
+	fs.Delete(context.Background(), 1)
+	if rec := serve(h, "PUT", "/api/users/1", `{"name": "Ada King"}`, "If-Match", etag); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("deleted user: status = %d, want 412", rec.Code)
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	for v, want := range map[string][]SortField{
+		"name":                 {{Column: "name"}},
+		"-created_at,id":       {{Column: "created_at", Desc: true}, {Column: "id"}},
+		"createdAt,-updatedAt": {{Column: "created_at"}, {Column: "updated_at", Desc: true}},
+	} {
+		got, err := parseSort(v)
+		if err != nil || !slices.Equal(got, want) {
+			t.Errorf("parseSort(%q) = %v, %v; want %v", v, got, err, want)
+		}
+	}
+	for _, v := range []string{"", "password", "name,", "--name", "CreatedAt"} {
+		if _, err := parseSort(v); err == nil {
+			t.Errorf("parseSort(%q): no error", v)
+		}
+	}
+}
+
+func TestWritesRejectUnknownFields(t *testing.T) {
+	useFakeStore(t, "Ada")
+	h := newTestHandler(testConfig(t))
+
+	for _, req := range [][3]string{
+		{"POST", "/api/users", `{"nmae": "x"}`},
+		{"PUT", "/api/users/1", `{"nmae": "x"}`},
+		{"PATCH", "/api/users/1", `{"name": "x", "nmae": "x"}`},
+		{"PATCH", "/api/users/bulk", `{"ids": [1], "nmae": "x"}`},
+		{"POST", "/api/users/search", `{"nmae": "x"}`},
+	} {
+		rec := serve(h, req[0], req[1], req[2])
+		var resp struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != http.StatusBadRequest || resp.Error != `unknown field "nmae"` {
+			t.Errorf("%s %s %s: %d %s, want 400 naming nmae", req[0], req[1], req[2], rec.Code, rec.Body)
+		}
+	}
+	if u, _ := store.Get(context.Background(), 1); u.Name != "Ada" {
+		t.Errorf("name = %q, want the user untouched", u.Name)
+	}
+}
+
+func TestHeadMatchesGet(t *testing.T) {
+	useFakeStore(t, "Ada", "Grace")
+	// 👇 A real server, since it is what drops the body of a HEAD response
+	srv, url := startServer(t, newTestHandler(testConfig(t)))
+	defer srv.Close()
+
+	for _, path := range []string{"/api/users", "/api/users?limit=1", "/api/users/1"} {
+		get, err := http.Get(url + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(get.Body)
+		get.Body.Close()
+		head, err := http.Head(url + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		headBody, _ := io.ReadAll(head.Body)
+		head.Body.Close()
+
+		if head.StatusCode != get.StatusCode || len(headBody) != 0 {
+			t.Errorf("HEAD %s: status %d with %d body bytes, want %d and none", path, head.StatusCode, len(headBody), get.StatusCode)
+		}
+		if got := head.Header.Get("Content-Length"); got != strconv.Itoa(len(body)) {
+			t.Errorf("HEAD %s: Content-Length = %q, want %d like GET", path, got, len(body))
+		}
+		for _, key := range []string{"Content-Type", "ETag", "Last-Modified", "X-Total-Count", "Link"} {
+			if head.Header.Get(key) != get.Header.Get(key) {
+				t.Errorf("HEAD %s: %s = %q, GET had %q", path, key, head.Header.Get(key), get.Header.Get(key))
+			}
+		}
+	}
+
+	head, err := http.Head(url + "/api/users/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	head.Body.Close()
+	if head.StatusCode != http.StatusNotFound {
+		t.Errorf("HEAD of a missing user: status = %d, want 404", head.StatusCode)
+	}
+}
+
+func TestWritesRequireJSON(t *testing.T) {
+	fs := useFakeStore(t, "Ada")
+	h := newTestHandler(testConfig(t))
+
+	for _, contentType := range []string{"text/plain", "application/x-www-form-urlencoded", ""} {
+		rec := serve(h, "POST", "/api/users", `{"name": "Grace"}`, "Content-Type", contentType)
+		if rec.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("POST as %q: status = %d, want 415", contentType, rec.Code)
+		}
+		if rec := serve(h, "PUT", "/api/users/1", `{"name": "Grace"}`, "Content-Type", contentType); rec.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("PUT as %q: status = %d, want 415", contentType, rec.Code)
+		}
+	}
+	if n, _ := fs.Count(context.Background()); n != 1 {
+		t.Errorf("store holds %d users, want nothing created", n)
+	}
+
+	rec := serve(h, "POST", "/api/users", `{"name": "Grace"}`, "Content-Type", "application/json; charset=utf-8")
+	if rec.Code != http.StatusCreated {
+		t.Errorf("charset suffix: status = %d, want 201", rec.Code)
+	}
+	// GETs don't need a Content-Type
+	if rec := serve(h, "GET", "/api/users", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestListSortedByMultipleFields(t *testing.T) {
+	fs := useFakeStore(t, "Bob", "Ada", "Bob", "Ada")
+	fs.users[0].CreatedAt = fs.users[2].CreatedAt.Add(time.Hour) // the first Bob is newer
+	h := newTestHandler(testConfig(t))
+
+	rec := serve(h, "GET", "/api/users?sort=name,-created_at", "")
+	var users []User
+	json.Unmarshal(rec.Body.Bytes(), &users)
+	var ids []int
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	if !slices.Equal(ids, []int{4, 2, 1, 3}) {
+		t.Errorf("ids = %v, want [4 2 1 3]", ids)
+	}
+
+	// One bad field rejects the whole list
+	if rec := serve(h, "GET", "/api/users?sort=name,password", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid second field: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestEmptyResultsAreArrays(t *testing.T) {
+	useFakeStore(t, "Ada")
+	useFlags(t, map[string]bool{"fts_search": true})
+	h := newTestHandler(testConfig(t))
+
+	for _, path := range []string{"/api/users?offset=10", "/api/users?offset=10&fields=id", "/api/users/search?q=nobody"} {
+		if rec := serve(h, "GET", path, ""); strings.TrimSpace(rec.Body.String()) != "[]" {
+			t.Errorf("GET %s = %s, want []", path, rec.Body)
+		}
+	}
+	rec := serve(h, "POST", "/api/users/search", `{"name_contains": "nobody"}`)
+	if !strings.Contains(rec.Body.String(), `"users":[]`) {
+		t.Errorf("POST /api/users/search = %s, want \"users\":[]", rec.Body)
+	}
+}
+
+func TestBulkUpdateUsers(t *testing.T) {
+	fs := useFakeStore(t, "Ada", "Grace", "Linus")
+	h := newTestHandler(testConfig(t))
+
+	rec := serve(h, "PATCH", "/api/users/bulk", `{"ids": [1, 3, 99], "name": "Renamed"}`)
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"updated":2}` {
+		t.Fatalf("got %d %s, want 200 {\"updated\":2}", rec.Code, rec.Body)
+	}
+	for id, want := range map[int]string{1: "Renamed", 2: "Grace", 3: "Renamed"} {
+		if u, _ := fs.Get(context.Background(), id); u.Name != want {
+			t.Errorf("user %d is %q, want %q", id, u.Name, want)
+		}
+	}
+
+	for _, body := range []string{
+		`{"ids": [], "name": "Renamed"}`,
+		`{"name": "Renamed"}`,
+		`{"ids": [1, 0], "name": "Renamed"}`,
+		`{"ids": [1, -2], "name": "Renamed"}`,
+		`{"ids": [1], "name": ""}`,
+		`{"ids": [1], "name": "Renamed", "role": "admin"}`,
+		`{"ids": "1", "name": "Renamed"}`,
+	} {
+		if rec := serve(h, "PATCH", "/api/users/bulk", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+	tooMany, _ := json.Marshal(map[string]interface{}{"ids": make([]int, maxBulkIDs+1), "name": "Renamed"})
+	if rec := serve(h, "PATCH", "/api/users/bulk", string(tooMany)); rec.Code != http.StatusBadRequest {
+		t.Errorf("%d ids: status = %d, want 400", maxBulkIDs+1, rec.Code)
+	}
+}
+
+func TestValidationDetailsListEveryViolation(t *testing.T) {
+	useFakeStore(t, "Ada")
+	h := newTestHandler(testConfig(t))
+	long := strings.Repeat("x", maxNameLength+1)
+
+	for _, tc := range []struct {
+		method, path, body string
+		want               []fieldError
+	}{
+		{"POST", "/api/users", `{"name": "` + long + `", "email": "not-an-email"}`, []fieldError{
+			{Field: "email", Message: "is not allowed"},
+			{Field: "name", Message: "length must be <= 100, but got 101"},
+		}},
+		{"PUT", "/api/users/1", `{}`, []fieldError{{Field: "name", Message: "is required"}}},
+		{"PATCH", "/api/users/1", `{"name": "   "}`, []fieldError{{Field: "name", Message: "name is required"}}},
+	} {
+		rec := serve(h, tc.method, tc.path, tc.body)
+		var resp struct {
+			Details []fieldError `json:"details"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		slices.SortFunc(resp.Details, func(a, b fieldError) int { return strings.Compare(a.Field, b.Field) })
+		if rec.Code != http.StatusBadRequest || !slices.Equal(resp.Details, tc.want) {
+			t.Errorf("%s %s %s: got %d %+v, want 400 %+v", tc.method, tc.path, tc.body, rec.Code, resp.Details, tc.want)
+		}
+	}
+}
+
+// laggingStore serves Get from a snapshot taken before later writes, like
+// a read replica that hasn't caught up; GetPrimary sees the latest data
+type laggingStore struct {
+	*fakeStore
+	snapshot map[int]User
+}
+
+func (s *laggingStore) Get(ctx context.Context, id int) (User, error) {
+	if u, ok := s.snapshot[id]; ok {
+		return u, nil
+	}
+	return s.fakeStore.Get(ctx, id)
+}
+
+func (s *laggingStore) GetPrimary(ctx context.Context, id int) (User, error) {
+	return s.fakeStore.Get(ctx, id)
+}
+
+func TestPatchUserIfMatch(t *testing.T) {
+	useFakeStore(t, "Ada")
+	h := newTestHandler(testConfig(t))
+
+	etag := serve(h, "GET", "/api/users/1", "").Header().Get("ETag")
+	rec := serve(h, "PATCH", "/api/users/1", `{"name": "Ada Lovelace"}`, "If-Match", `"other", `+etag)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ETag among several: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	// The ETag returned by the update is the one a GET now computes
+	current := rec.Header().Get("ETag")
+	if got := serve(h, "GET", "/api/users/1", "").Header().Get("ETag"); got != current || current == etag {
+		t.Errorf("PATCH returned ETag %s, GET %s (was %s)", current, got, etag)
+	}
+	if rec := serve(h, "PATCH", "/api/users/1", `{"name": "Ada King"}`, "If-Match", etag); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale ETag: status = %d, want 412", rec.Code)
+	}
+	if rec := serve(h, "PATCH", "/api/users/1", `{"name": "Ada King"}`, "If-Match", current); rec.Code != http.StatusOK {
+		t.Errorf("current ETag: status = %d, want 200", rec.Code)
+	}
+	if rec := serve(h, "PATCH", "/api/users/1", `{"name": "Ada"}`, "If-Match", "*"); rec.Code != http.StatusOK {
+		t.Errorf("If-Match *: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestIfMatchCheckedAgainstPrimary(t *testing.T) {
+	fs := useFakeStore(t, "Ada")
+	stale, _ := fs.Get(context.Background(), 1)
+	fs.Update(context.Background(), 1, "Ada Lovelace")
+	store = &laggingStore{fakeStore: fs, snapshot: map[int]User{1: stale}}
+	h := newTestHandler(testConfig(t))
+
+	// The replica still vouches for the old ETag; the primary doesn't
+	etag := serve(h, "GET", "/api/users/1", "").Header().Get("ETag")
+	if etag != userETag(stale) {
+		t.Fatalf("GET ETag = %s, want the replica's %s", etag, userETag(stale))
+	}
+	if rec := serve(h, "PATCH", "/api/users/1", `{"name": "Ada King"}`, "If-Match", etag); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("ETag only current on the replica: status = %d, want 412", rec.Code)
+	}
+	if u, _ := fs.Get(context.Background(), 1); u.Name != "Ada Lovelace" {
+		t.Errorf("name = %q after a failed precondition", u.Name)
+	}
+}
+
+func TestTimeFormats(t *testing.T) {
+	prev := timeFormat
+	t.Cleanup(func() { timeFormat = prev })
+	created := time.Date(2024, 3, 1, 12, 30, 45, 123_000_000, time.UTC)
+	u := User{ID: 1, Name: "Ada", CreatedAt: created, UpdatedAt: created.Add(time.Second)}
+	if cfg := testConfig(t); cfg.TimeFormat != "rfc3339" {
+		t.Errorf("default TIME_FORMAT = %q, want rfc3339", cfg.TimeFormat)
+	}
+
+	for format, want := range map[string]string{
+		"rfc3339":   `{"id":1,"name":"Ada","created_at":"2024-03-01T12:30:45.123Z","updated_at":"2024-03-01T12:30:46.123Z"}`,
+		"unix":      `{"id":1,"name":"Ada","created_at":1709296245,"updated_at":1709296246}`,
+		"unixmilli": `{"id":1,"name":"Ada","created_at":1709296245123,"updated_at":1709296246123}`,
+	} {
+		cfg := testConfig(t, "TIME_FORMAT", format)
+		timeFormat = cfg.TimeFormat
+		if got, err := json.Marshal(u); err != nil || string(got) != want {
+			t.Errorf("TIME_FORMAT=%s: got %s, %v; want %s", format, got, err, want)
+		}
+	}
+	if err := configError(t, "TIME_FORMAT", "iso"); err == nil {
+		t.Error("TIME_FORMAT=iso was accepted")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// userSchemaJSON is the contract for create/update request bodies
+//
+//go:embed schemas/user.json
+var userSchemaJSON string
+
+var userSchema = jsonschema.MustCompileString("user.json", userSchemaJSON)
+
+// quotedName pulls the property names out of messages like
+// "missing properties: 'name'"
+var quotedName = regexp.MustCompile(`'([^']*)'`)
+
+// schemaViolations flattens a JSON Schema validation error into one entry
+// per failed rule, naming the property it applies to
+func schemaViolations(err error) []fieldError {
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return []fieldError{{Message: err.Error()}}
+	}
+
+	var out []fieldError
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			field := strings.ReplaceAll(strings.TrimPrefix(e.InstanceLocation, "/"), "/", ".")
+			// 👇 required and additionalProperties fail on the parent object;
+			// report them against the properties they name instead
+			message := ""
+			switch path.Base(e.KeywordLocation) {
+			case "required":
+				message = "is required"
+			case "additionalProperties":
+				message = "is not allowed"
+			}
+			names := quotedName.FindAllStringSubmatch(e.Message, -1)
+			if message == "" || len(names) == 0 {
+				out = append(out, fieldError{Field: field, Message: e.Message})
+				return
+			}
+			for _, name := range names {
+				out = append(out, fieldError{Field: strings.TrimPrefix(field+"."+name[1], "."), Message: message})
+			}
+			return
+		}
+		for _, c := range e.Causes {
+			walk(c)
+		}
+	}
+	walk(ve)
+	return out
+}
+
+// nameWarningRule inspects a valid name and returns a non-fatal warning, or
+// "" when the name is fine. Rules never block a create.
+type nameWarningRule func(name string) string
+
+// nameWarningRules are checked on create; add a rule here to surface more
+// soft guidance to clients
+var nameWarningRules = []nameWarningRule{
+	func(name string) string {
+		if strings.ToUpper(name) == name && strings.ToLower(name) != name {
+			return "name is all uppercase"
+		}
+		return ""
+	},
+	func(name string) string {
+		if strings.ContainsFunc(name, unicode.IsDigit) {
+			return "name contains digits"
+		}
+		return ""
+	},
+}
+
+// nameWarnings runs every rule against name
+func nameWarnings(name string) []string {
+	var warnings []string
+	for _, rule := range nameWarningRules {
+		if w := rule(name); w != "" {
+			warnings = append(warnings, w)
+		}
+	}
+	return warnings
+}